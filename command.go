@@ -0,0 +1,215 @@
+package puppetdb
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// storeReportVersion is the version of the store report command submitted by StoreReport.
+	storeReportVersion = 8
+	// deactivateNodeVersion is the version of the deactivate node command.
+	deactivateNodeVersion = 3
+	// deactivateConcurrency is the number of deactivate node commands submitted at the same time.
+	deactivateConcurrency = 4
+)
+
+// commandRetryDelay is the time waited before a failed command submission is retried.
+var commandRetryDelay = time.Second
+
+// CommandResponseJSON A json object holding the response of the command api.
+type CommandResponseJSON struct {
+	UUID string `json:"uuid"`
+}
+
+// ReportPayload is the payload of a store report command.
+type ReportPayload struct {
+	Certname             string                         `json:"certname"`
+	Environment          string                         `json:"environment"`
+	PuppetVersion        string                         `json:"puppet_version"`
+	ReportFormat         int                            `json:"report_format"`
+	ConfigurationVersion string                         `json:"configuration_version"`
+	StartTime            string                         `json:"start_time"`
+	EndTime              string                         `json:"end_time"`
+	ProducerTimestamp    string                         `json:"producer_timestamp"`
+	Producer             string                         `json:"producer"`
+	TransactionUUID      string                         `json:"transaction_uuid"`
+	CatalogUUID          string                         `json:"catalog_uuid"`
+	CodeID               string                         `json:"code_id"`
+	CachedCatalogStatus  string                         `json:"cached_catalog_status"`
+	Status               string                         `json:"status"`
+	Noop                 bool                           `json:"noop"`
+	NoopPending          bool                           `json:"noop_pending"`
+	CorrectiveChange     bool                           `json:"corrective_change"`
+	Resources            []ReportPayloadResource        `json:"resources"`
+	Metrics              []PuppetReportMetricsDataEntry `json:"metrics"`
+	Logs                 []ReportPayloadLog             `json:"logs"`
+}
+
+// ReportPayloadResource is a resource of a store report command.
+type ReportPayloadResource struct {
+	ResourceType     string               `json:"resource_type"`
+	ResourceTitle    string               `json:"resource_title"`
+	Skipped          bool                 `json:"skipped"`
+	Timestamp        string               `json:"timestamp"`
+	File             string               `json:"file"`
+	Line             int                  `json:"line"`
+	ContainmentPath  []string             `json:"containment_path"`
+	CorrectiveChange bool                 `json:"corrective_change"`
+	Events           []ReportPayloadEvent `json:"events"`
+}
+
+// ReportPayloadEvent is an event of a resource of a store report command.
+type ReportPayloadEvent struct {
+	Status           string      `json:"status"`
+	Timestamp        string      `json:"timestamp"`
+	Property         string      `json:"property"`
+	NewValue         interface{} `json:"new_value"`
+	OldValue         interface{} `json:"old_value"`
+	Message          string      `json:"message"`
+	CorrectiveChange bool        `json:"corrective_change"`
+}
+
+// ReportPayloadLog is a log entry of a store report command.
+type ReportPayloadLog struct {
+	File    string   `json:"file"`
+	Line    int      `json:"line"`
+	Level   string   `json:"level"`
+	Message string   `json:"message"`
+	Source  string   `json:"source"`
+	Tags    []string `json:"tags"`
+	Time    string   `json:"time"`
+}
+
+// StoreReport submits a store report command with the report and returns the uuid of the command.
+func (c *Client) StoreReport(report ReportPayload) (string, error) {
+	// puppetdb rejects null for the mandatory lists
+	if report.Resources == nil {
+		report.Resources = []ReportPayloadResource{}
+	}
+	if report.Metrics == nil {
+		report.Metrics = []PuppetReportMetricsDataEntry{}
+	}
+	if report.Logs == nil {
+		report.Logs = []ReportPayloadLog{}
+	}
+	return c.SubmitCommand("store report", storeReportVersion, report.Certname, report)
+}
+
+// DeactivateNodePayload is the payload of a deactivate node command.
+type DeactivateNodePayload struct {
+	Certname          string `json:"certname"`
+	ProducerTimestamp string `json:"producer_timestamp"`
+}
+
+// DeactivateNodesByQuery resolves the certnames of the nodes matching the query and submits a
+// deactivate node command for each of them. It returns the command uuid per certname of the
+// submitted commands. If some submissions fail the error lists the failed certnames and the
+// returned map holds the successful ones.
+func (c *Client) DeactivateNodesByQuery(ctx context.Context, query string) (map[string]string, error) {
+	ret := map[string]string{}
+	certnames, err := c.certnames(ctx, query)
+	if err != nil {
+		return ret, err
+	}
+
+	failures := []string{}
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	semaphore := make(chan struct{}, deactivateConcurrency)
+	for _, certname := range certnames {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(certname string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			payload := DeactivateNodePayload{certname, time.Now().UTC().Format(time.RFC3339)}
+			uuid, err := c.SubmitCommandContext(ctx, "deactivate node", deactivateNodeVersion, certname, payload)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", certname, err))
+				return
+			}
+			ret[certname] = uuid
+		}(certname)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return ret, fmt.Errorf("Deactivating %d of %d nodes failed: %s",
+			len(failures), len(certnames), strings.Join(failures, ", "))
+	}
+	return ret, nil
+}
+
+// SubmitCommand submits a command with the given version and payload for the node to the command
+// api and returns the uuid puppetdb assigned to it. Submissions failing with a connection error or a
+// 5xx response are retried CommandRetries times, other failures are returned right away. The
+// checksum only lets puppetdb verify the integrity of the payload, puppetdb does not deduplicate
+// commands. Retries therefore give at-least-once delivery: a retry of a submission whose response
+// got lost can store the command twice.
+func (c *Client) SubmitCommand(command string, version int, certname string, payload interface{}) (string, error) {
+	return c.SubmitCommandContext(context.Background(), command, version, certname, payload)
+}
+
+// SubmitCommandContext is like SubmitCommand but the submission and its retries are bound to the context.
+func (c *Client) SubmitCommandContext(ctx context.Context, command string, version int, certname string, payload interface{}) (string, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	checksum := sha1.Sum(body)
+	params := url.Values{}
+	params.Set("command", strings.Replace(command, " ", "_", -1))
+	params.Set("version", fmt.Sprint(version))
+	params.Set("certname", certname)
+	params.Set("checksum", hex.EncodeToString(checksum[:]))
+	path := "/pdb/cmd/v1?" + params.Encode()
+
+	for attempt := 0; ; attempt++ {
+		var uuid string
+		uuid, err = c.postCommand(ctx, path, body)
+		if err == nil || attempt >= c.CommandRetries || !retryableCommandError(err) {
+			return uuid, err
+		}
+		log.Printf("Retrying command %s for %s: %v", command, certname, err)
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(commandRetryDelay):
+		}
+	}
+}
+
+// postCommand posts a single command submission and decodes the returned uuid.
+func (c *Client) postCommand(ctx context.Context, path string, body []byte) (string, error) {
+	resp, err := c.httpPostPath(ctx, path, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, URL: resp.Request.URL.String()}
+	}
+	ret := CommandResponseJSON{}
+	err = json.NewDecoder(resp.Body).Decode(&ret)
+	return ret.UUID, err
+}
+
+// retryableCommandError returns whether a failed command submission can be retried, which is the
+// case for connection errors and server errors but not for rejected commands.
+func retryableCommandError(err error) bool {
+	httpErr, ok := err.(*HTTPError)
+	return !ok || httpErr.StatusCode >= 500
+}
@@ -0,0 +1,158 @@
+package puppetdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubmitCommandRetriesWithSameChecksum(t *testing.T) {
+	setup()
+	defer teardown()
+	defer func(delay time.Duration) { commandRetryDelay = delay }(commandRetryDelay)
+	commandRetryDelay = 0
+	client.CommandRetries = 2
+
+	checksums := []string{}
+	mux.HandleFunc("/pdb/cmd/v1",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "POST")
+			body, _ := ioutil.ReadAll(r.Body)
+			if string(body) != `{"certname":"node123","producer_timestamp":"2019-02-19T13:27:21.282Z"}` {
+				t.Errorf("Command body = %s", body)
+			}
+			if got := r.URL.Query().Get("command"); got != "deactivate_node" {
+				t.Errorf("Command = %q, want %q", got, "deactivate_node")
+			}
+			checksums = append(checksums, r.URL.Query().Get("checksum"))
+			if len(checksums) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprint(w, `{"uuid": "0aa7b6f0-7a4f-4d1a-8e1c-0f3a5a2b8c9d"}`)
+		})
+
+	payload := map[string]string{"certname": "node123", "producer_timestamp": "2019-02-19T13:27:21.282Z"}
+	uuid, err := client.SubmitCommand("deactivate node", 3, "node123", payload)
+	if err != nil {
+		t.Errorf("SubmitCommand() returned error: %v", err)
+	}
+	if uuid != "0aa7b6f0-7a4f-4d1a-8e1c-0f3a5a2b8c9d" {
+		t.Errorf("SubmitCommand() returned uuid %q", uuid)
+	}
+	if len(checksums) != 2 || checksums[0] == "" || checksums[0] != checksums[1] {
+		t.Errorf("SubmitCommand() sent checksums %v, want the same checksum twice", checksums)
+	}
+}
+
+func TestSubmitCommandDoesNotRetryRejectedCommand(t *testing.T) {
+	setup()
+	defer teardown()
+	defer func(delay time.Duration) { commandRetryDelay = delay }(commandRetryDelay)
+	commandRetryDelay = 0
+	client.CommandRetries = 2
+
+	attempts := 0
+	mux.HandleFunc("/pdb/cmd/v1",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "POST")
+			attempts++
+			http.Error(w, "unsupported command version", http.StatusBadRequest)
+		})
+
+	_, err := client.SubmitCommand("deactivate node", 99, "node123", map[string]string{"certname": "node123"})
+	httpErr, ok := err.(*HTTPError)
+	if !ok || httpErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("SubmitCommand() returned error %v, want an *HTTPError with status 400", err)
+	}
+	if attempts != 1 {
+		t.Errorf("SubmitCommand() sent %d submissions for a rejected command, want 1", attempts)
+	}
+}
+
+func TestStoreReport(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/cmd/v1",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "POST")
+			params := r.URL.Query()
+			if params.Get("command") != "store_report" || params.Get("version") != "8" || params.Get("certname") != "node123" {
+				t.Errorf("StoreReport() sent command %v", params)
+			}
+			payload := ReportPayload{}
+			err := json.NewDecoder(r.Body).Decode(&payload)
+			if err != nil {
+				t.Errorf("StoreReport() sent invalid payload: %v", err)
+			}
+			if payload.Certname != "node123" || payload.Status != "unchanged" || payload.Resources == nil || payload.Logs == nil {
+				t.Errorf("StoreReport() sent payload %+v", payload)
+			}
+			fmt.Fprint(w, `{"uuid": "d8a3a7c5-5b2a-4c1e-9f0a-4e3b2c1d0e9f"}`)
+		})
+
+	uuid, err := client.StoreReport(ReportPayload{
+		Certname:        "node123",
+		Environment:     "production",
+		TransactionUUID: "005d2b4a-5a89-4096-9f81-ecc65f1e9082",
+		Status:          "unchanged",
+		Metrics:         []PuppetReportMetricsDataEntry{{Name: "total", Value: 12, Category: "resources"}},
+	})
+	if err != nil {
+		t.Errorf("StoreReport() returned error: %v", err)
+	}
+	if uuid != "d8a3a7c5-5b2a-4c1e-9f0a-4e3b2c1d0e9f" {
+		t.Errorf("StoreReport() returned uuid %q", uuid)
+	}
+}
+
+func TestDeactivateNodesByQuery(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["extract","certname",["=","catalog_environment","staging"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("DeactivateNodesByQuery() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"certname": "node1"}, {"certname": "node2"}, {"certname": "node3"}]`)
+		})
+	mutex := sync.Mutex{}
+	deactivated := []string{}
+	mux.HandleFunc("/pdb/cmd/v1",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "POST")
+			if got := r.URL.Query().Get("command"); got != "deactivate_node" {
+				t.Errorf("Command = %q, want %q", got, "deactivate_node")
+			}
+			payload := DeactivateNodePayload{}
+			json.NewDecoder(r.Body).Decode(&payload)
+			mutex.Lock()
+			deactivated = append(deactivated, payload.Certname)
+			mutex.Unlock()
+			fmt.Fprintf(w, `{"uuid": "uuid-%s"}`, payload.Certname)
+		})
+
+	uuids, err := client.DeactivateNodesByQuery(context.Background(), `["=","catalog_environment","staging"]`)
+	if err != nil {
+		t.Errorf("DeactivateNodesByQuery() returned error: %v", err)
+	}
+	want := map[string]string{"node1": "uuid-node1", "node2": "uuid-node2", "node3": "uuid-node3"}
+	if !reflect.DeepEqual(uuids, want) {
+		t.Errorf("DeactivateNodesByQuery() returned %+v, want %+v", uuids, want)
+	}
+	sort.Strings(deactivated)
+	if !reflect.DeepEqual(deactivated, []string{"node1", "node2", "node3"}) {
+		t.Errorf("DeactivateNodesByQuery() deactivated %v", deactivated)
+	}
+}
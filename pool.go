@@ -0,0 +1,91 @@
+package puppetdb
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// poolDeadTime is how long an endpoint that failed to respond is skipped.
+var poolDeadTime = 30 * time.Second
+
+// endpointPool holds the puppetdb endpoints of a pool client and which of them are marked dead.
+type endpointPool struct {
+	mutex     sync.Mutex
+	urls      []string
+	next      int
+	deadUntil map[string]time.Time
+}
+
+// NewClientPool returns a http connection that spreads the requests round-robin over the puppetdb
+// instances, e.g. read replicas. When an instance can not be reached the request is retried on the
+// next one and the failing instance is skipped for a while.
+func NewClientPool(urls []string, verbose bool) *Client {
+	client := &http.Client{Timeout: DefaultTimeout}
+	endpoints := []string{}
+	for _, u := range urls {
+		endpoints = append(endpoints, normalizeBaseURL(u))
+	}
+	baseURL := ""
+	if len(endpoints) > 0 {
+		baseURL = endpoints[0]
+	}
+	pool := &endpointPool{urls: endpoints, deadUntil: map[string]time.Time{}}
+	return &Client{BaseURL: baseURL, FollowRedirects: true, httpClient: client, verbose: verbose, pool: pool}
+}
+
+// endpoints returns the endpoints in the order they should be tried: the live endpoints starting
+// round-robin, followed by the dead endpoints as a last resort.
+func (p *endpointPool) endpoints() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	live := []string{}
+	dead := []string{}
+	for i := range p.urls {
+		url := p.urls[(p.next+i)%len(p.urls)]
+		if now().Before(p.deadUntil[url]) {
+			dead = append(dead, url)
+		} else {
+			live = append(live, url)
+		}
+	}
+	if len(p.urls) > 0 {
+		p.next = (p.next + 1) % len(p.urls)
+	}
+	return append(live, dead...)
+}
+
+// markDead skips the endpoint for poolDeadTime.
+func (p *endpointPool) markDead(url string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.deadUntil[url] = now().Add(poolDeadTime)
+}
+
+// markAlive stops skipping the endpoint.
+func (p *endpointPool) markAlive(url string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.deadUntil, url)
+}
+
+// do sends the request to the endpoints until one of them responds.
+func (p *endpointPool) do(ctx context.Context, c *Client, method string, path string, body []byte) (resp *http.Response, err error) {
+	err = errors.New("No puppetdb endpoints configured")
+	for _, url := range p.endpoints() {
+		resp, err = c.httpSend(ctx, method, url, path, body)
+		if _, ok := err.(*HTTPError); err == nil || ok {
+			p.markAlive(url)
+			return resp, err
+		}
+		if ctx.Err() != nil {
+			return resp, err
+		}
+		log.Printf("Puppetdb endpoint %s failed: %v", url, err)
+		p.markDead(url)
+	}
+	return resp, err
+}
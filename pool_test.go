@@ -0,0 +1,35 @@
+package puppetdb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientPoolFailover(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{"certname": "node1"}]`)
+		}))
+	defer healthy.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+
+	pool := NewClientPool([]string{dead.URL, healthy.URL}, true)
+	for i := 0; i < 2; i++ {
+		nodes, err := pool.Nodes()
+		if err != nil {
+			t.Errorf("Nodes() returned error: %v", err)
+		}
+		if len(nodes) != 1 || nodes[0].Certname != "node1" {
+			t.Errorf("Nodes() returned %+v", nodes)
+		}
+	}
+
+	endpoints := pool.pool.endpoints()
+	if endpoints[len(endpoints)-1] != dead.URL {
+		t.Errorf("Pool endpoints are %v, want the dead endpoint %s last", endpoints, dead.URL)
+	}
+}
@@ -1,28 +1,114 @@
 package puppetdb
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Jeffail/gabs"
 )
 
+// ErrMetricsDisabled is returned when the legacy metrics api is not found, as on puppetdb 6 and
+// later where metrics are served by the v2 api at /metrics/v2.
+var ErrMetricsDisabled = errors.New("Metrics api not found, use the /metrics/v2 endpoint")
+
+// DefaultTimeout is the timeout of the http client of the constructors without a timeout argument,
+// so requests to an unreachable puppetdb do not hang forever. It can be changed with SetTimeout.
+const DefaultTimeout = 30 * time.Second
+
+// eventsPageSize is the number of events EventsStream requests at a time.
+var eventsPageSize = 1000
+
+// now returns the current time and is replaced in tests.
+var now = time.Now
+
 // Client This represents a connection to your puppetdb instance
 type Client struct {
-	BaseURL    string
-	Cert       string
-	Key        string
-	httpClient *http.Client
-	verbose    bool
+	BaseURL string
+	Cert    string
+	Key     string
+	// ValidateQueries checks the operators of every json query with ValidateQuery before it is sent,
+	// whether it is a query param or the body of a posted query. PQL queries are sent unchecked.
+	ValidateQueries bool
+	// CommandRetries is the number of times a failed command submission is retried.
+	CommandRetries int
+	// FollowRedirects follows redirects of puppetdb, which is the default of the constructors. When
+	// false a redirect, e.g. to the login page of a proxy, is returned as an *HTTPError.
+	FollowRedirects bool
+	// StrictDecode returns an error if a response can not be decoded or a result misses a
+	// required field like the certname of a node, instead of silently returning empty values.
+	StrictDecode bool
+	// Trace is attached to every request to observe the timings of dns lookups, connecting, the tls
+	// handshake and the response of puppetdb.
+	Trace *httptrace.ClientTrace
+	// GzipRequestThreshold gzips request bodies larger than this number of bytes, e.g. the body of a
+	// query for thousands of certnames. Zero never gzips.
+	GzipRequestThreshold int
+	// DecoderFunc decodes the query results instead of json.Decoder, e.g. to decode numbers with
+	// UseNumber or to use another json library.
+	DecoderFunc func(io.Reader, interface{}) error
+	httpClient  *http.Client
+	verbose     bool
+	userAgent   string
+	username    string
+	password    string
+	token       string
+	pool        *endpointPool
+	queryLog    *queryLog
+	// version is the major version of puppetdb detected by DetectVersion, guarded by versionMutex.
+	// versionDetected is also set when the detection failed, so it is not retried for every metric.
+	versionMutex    sync.Mutex
+	version         int
+	versionDetected bool
+}
+
+// HTTPError is returned when puppetdb responds with an unexpected http status.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	URL        string
+	// Location is the target of a redirect.
+	Location string
+	// RequestBody is the body of a failed POST request, e.g. the query sent to the root endpoint.
+	RequestBody string
+	// ResponseBody is the start of the body of the failed response, e.g. the parse error of a query,
+	// see maxErrorBodySize.
+	ResponseBody string
+}
+
+// maxErrorBodySize is the number of bytes of a failed response kept in the ResponseBody of an
+// HTTPError.
+const maxErrorBodySize = 4096
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("%s returned %s", e.URL, e.Status)
+	if e.Location != "" {
+		msg = fmt.Sprintf("%s redirecting to %s", msg, e.Location)
+	}
+	if e.RequestBody != "" {
+		msg = fmt.Sprintf("%s for request %s", msg, e.RequestBody)
+	}
+	if e.ResponseBody != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.ResponseBody)
+	}
+	return msg
 }
 
 // EventCountJSON A json object holding the results of a query to the eventcount api
@@ -35,24 +121,53 @@ type EventCountJSON struct {
 	Skips       int64             `json:"skips"`
 }
 
-// EventJSON A json object holding the results of a query to the event api.
+// EventJSON A json object holding the results of a query to the event api. OldValue and NewValue hold
+// any json value, e.g. a string, an array or a hash, depending on the property that changed.
 type EventJSON struct {
-	CertName          string `json:"certname"`
-	OldValue          string `json:"old-value"`
-	Property          string `json:"property"`
-	Timestamp         string `json:"timestamp"`
-	ResourceType      string `json:"resource-typ"`
-	ResourceTitle     string `json:"resource-title"`
-	NewValue          string `json:"new-value"`
-	Message           string `json:"message"`
-	Report            string `json:"report"`
-	Status            string `json:"status"`
-	File              string `json:"file"`
-	ContainmentPath   string `json:"containment-path"`
-	ContainmentClass  string `json:"containing-class"`
-	RunStartTime      string `json:"run-start-time"`
-	RunEndTime        string `json:"run-end-time"`
-	ReportReceiveTime string `json:"report-receive-time"`
+	CertName          string      `json:"certname"`
+	OldValue          interface{} `json:"old_value"`
+	Property          string      `json:"property"`
+	Timestamp         string      `json:"timestamp"`
+	ResourceType      string      `json:"resource_type"`
+	ResourceTitle     string      `json:"resource_title"`
+	NewValue          interface{} `json:"new_value"`
+	Message           string      `json:"message"`
+	Report            string      `json:"report"`
+	Status            string      `json:"status"`
+	File              string      `json:"file"`
+	ContainmentPath   []string    `json:"containment_path"`
+	ContainmentClass  string      `json:"containing_class"`
+	RunStartTime      string      `json:"run_start_time"`
+	RunEndTime        string      `json:"run_end_time"`
+	ReportReceiveTime string      `json:"report_receive_time"`
+}
+
+// ReportDiff holds the differences between the events of two reports, keyed on resource type, title
+// and property, as puppetdb has an event for every changed property of a resource.
+type ReportDiff struct {
+	Added   []EventJSON
+	Removed []EventJSON
+	Changed []EventChange
+}
+
+// EventChange holds the events of a resource that differ between two reports.
+type EventChange struct {
+	Old EventJSON
+	New EventJSON
+}
+
+// FactDiff holds the differences between the facts of two nodes, sorted by fact name.
+type FactDiff struct {
+	OnlyA   []FactJSON
+	OnlyB   []FactJSON
+	Changed []FactChange
+}
+
+// FactChange holds the values of a fact that differ between two nodes.
+type FactChange struct {
+	Name string
+	A    *gabs.Container
+	B    *gabs.Container
 }
 
 // FactJSON A json object holding the results of a query to the facts api.
@@ -63,6 +178,67 @@ type FactJSON struct {
 	Value       *gabs.Container `json:"value"`
 }
 
+// FactJSONNative A json object holding the results of a query to the facts api, with the value decoded
+// into native types: string, float64, bool, []interface{} or map[string]interface{}.
+type FactJSONNative struct {
+	CertName    string      `json:"certname"`
+	Environment string      `json:"environment"`
+	Name        string      `json:"name"`
+	Value       interface{} `json:"value"`
+}
+
+// FactsetJSON A json object holding the results of a query to the factsets api.
+type FactsetJSON struct {
+	Certname          string      `json:"certname"`
+	Environment       string      `json:"environment"`
+	Timestamp         string      `json:"timestamp"`
+	ProducerTimestamp string      `json:"producer_timestamp"`
+	Hash              string      `json:"hash"`
+	Producer          string      `json:"producer"`
+	Facts             FactsetData `json:"facts"`
+}
+
+// FactsetData holds the facts of a factset, with the href they can be fetched from.
+type FactsetData struct {
+	Href string            `json:"href"`
+	Data []FactsetFactJSON `json:"data"`
+}
+
+// FactsetFactJSON A single fact of a factset.
+type FactsetFactJSON struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+// ValueType returns the json type of the fact value: string, number, boolean, array, map or null.
+func (f FactJSON) ValueType() string {
+	if f.Value == nil {
+		return "null"
+	}
+	switch f.Value.Data().(type) {
+	case string:
+		return "string"
+	case float64, json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "map"
+	}
+	return "null"
+}
+
+// InventoryJSON A json object holding the results of a query to the inventory api.
+type InventoryJSON struct {
+	Certname    string                 `json:"certname"`
+	Timestamp   string                 `json:"timestamp"`
+	Environment string                 `json:"environment"`
+	Facts       map[string]interface{} `json:"facts"`
+	Trusted     map[string]interface{} `json:"trusted"`
+}
+
 // NodeJSON A json object holding the results of query to the node api.
 type NodeJSON struct {
 	Certname                     string `json:"certname"`
@@ -83,24 +259,121 @@ type NodeJSON struct {
 	LatestReportStatus           string `json:"latest_report_status"`
 }
 
+// CatalogJSON A json object holding the results of a query to the catalogs api.
+type CatalogJSON struct {
+	Certname          string           `json:"certname"`
+	Version           string           `json:"version"`
+	TransactionUUID   string           `json:"transaction_uuid"`
+	CatalogUUID       string           `json:"catalog_uuid"`
+	CodeID            string           `json:"code_id"`
+	ProducerTimestamp string           `json:"producer_timestamp"`
+	Hash              string           `json:"hash"`
+	Environment       string           `json:"environment"`
+	Producer          string           `json:"producer"`
+	Resources         CatalogResources `json:"resources"`
+	Edges             CatalogEdges     `json:"edges"`
+}
+
+// CatalogResources holds the resources of a catalog.
+type CatalogResources struct {
+	Href string     `json:"href"`
+	Data []Resource `json:"data"`
+}
+
+// CatalogEdges holds the edges of a catalog.
+type CatalogEdges struct {
+	Href string     `json:"href"`
+	Data []EdgeJSON `json:"data"`
+}
+
+// EdgeJSON A json object holding a relationship between two resources of a catalog.
+type EdgeJSON struct {
+	Certname     string `json:"certname"`
+	Relationship string `json:"relationship"`
+	SourceType   string `json:"source_type"`
+	SourceTitle  string `json:"source_title"`
+	TargetType   string `json:"target_type"`
+	TargetTitle  string `json:"target_title"`
+}
+
+// CatalogSummaryJSON A summary of a catalog holding its number of resources and the applied classes.
+type CatalogSummaryJSON struct {
+	ResourceCount int      `json:"resource_count"`
+	Classes       []string `json:"classes"`
+}
+
 // Version a simple struct holding the puppetdb version.
 type Version struct {
 	Version string `json:"version"`
 }
 
+// SemVer returns the major, minor and patch numbers of the version. A suffix like -SNAPSHOT is
+// ignored and missing minor or patch numbers are returned as 0.
+func (v Version) SemVer() (major int, minor int, patch int, err error) {
+	version := strings.SplitN(strings.SplitN(v.Version, "+", 2)[0], "-", 2)[0]
+	parts := strings.Split(version, ".")
+	if len(parts) > 3 {
+		return 0, 0, 0, fmt.Errorf("Unable to parse puppetdb version %q", v.Version)
+	}
+	numbers := []int{0, 0, 0}
+	for i, part := range parts {
+		numbers[i], err = strconv.Atoi(part)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("Unable to parse puppetdb version %q", v.Version)
+		}
+	}
+	return numbers[0], numbers[1], numbers[2], nil
+}
+
+// AtLeast returns whether the version is at least major.minor. An unparsable version is never at least
+// any version.
+func (v Version) AtLeast(major int, minor int) bool {
+	vMajor, vMinor, _, err := v.SemVer()
+	if err != nil {
+		return false
+	}
+	return vMajor > major || (vMajor == major && vMinor >= minor)
+}
+
 type PuppetReportLog struct {
 	Href string                        `json:"href"`
 	Data []PuppetReportMetricsLogEntry `json:"data"`
 }
 
+// PuppetReportResource holds the resource events of a report. Data is nil when the events were not
+// loaded and non-nil, possibly empty, once they were loaded.
 type PuppetReportResource struct {
-	Href string `json:"href"`
+	Href string      `json:"href"`
+	Data []EventJSON `json:"data"`
 }
 type PuppetReportMetrics struct {
 	Href string                         `json:"href"`
 	Data []PuppetReportMetricsDataEntry `json:"data"`
 }
 
+// Get returns the value of the metric with the given category and name, e.g. time and total, and
+// whether the report has the metric.
+func (m PuppetReportMetrics) Get(category string, name string) (float64, bool) {
+	for _, metric := range m.Data {
+		if metric.Category == category && metric.Name == name {
+			return metric.Value, true
+		}
+	}
+	return 0, false
+}
+
+// Category returns the values of all metrics of the category keyed on their name. A category the
+// report has no metrics of returns an empty map.
+func (m PuppetReportMetrics) Category(category string) map[string]float64 {
+	ret := map[string]float64{}
+	for _, metric := range m.Data {
+		if metric.Category == category {
+			ret[metric.Name] = metric.Value
+		}
+	}
+	return ret
+}
+
 type PuppetReportMetricsDataEntry struct {
 	Name     string  `json:"name"`
 	Value    float64 `json:"value"`
@@ -119,7 +392,8 @@ type PuppetReportMetricsLogEntry struct {
 	Message  string   `json:"message"`
 }
 
-// ReportJSON A json abject holding the data for a query from the report api.
+// ReportJSON A json abject holding the data for a query from the report api. CorrectiveChange is nil
+// when puppet did not report whether the changes were corrective.
 type ReportJSON struct {
 	CertName             string               `json:"certname"`
 	PuppetVersion        string               `json:"puppet_version"`
@@ -134,7 +408,7 @@ type ReportJSON struct {
 	ReceiveTime          string               `json:"receive_time"`
 	Noop                 bool                 `json:"noop"`
 	Producer             string               `json:"producer"`
-	CorrectiveChange     string               `json:"corrective_change"`
+	CorrectiveChange     *bool                `json:"corrective_change"`
 	Logs                 PuppetReportLog      `json:"logs"`
 	ProducerTimestamp    string               `json:"producer_timestamp"`
 	CachedCatalogStatus  string               `json:"cached_catalog_status"`
@@ -146,6 +420,42 @@ type ReportJSON struct {
 	Metrics              PuppetReportMetrics  `json:"metrics"`
 }
 
+// Duration returns the run time of the report from its start to its end time.
+func (r ReportJSON) Duration() (time.Duration, error) {
+	start, err := time.Parse(time.RFC3339, r.StartTime)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid start time of report %s: %v", r.Hash, err)
+	}
+	end, err := time.Parse(time.RFC3339, r.EndTime)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid end time of report %s: %v", r.Hash, err)
+	}
+	return end.Sub(start), nil
+}
+
+// ExceededSLA returns whether the run of the report took longer than d. A report with malformed
+// timestamps is not considered to exceed it.
+func (r ReportJSON) ExceededSLA(d time.Duration) bool {
+	duration, err := r.Duration()
+	return err == nil && duration > d
+}
+
+// ChangedResourceCount returns the number of resources changed by the run of the report, or 0 if the
+// report has no changed metric.
+func (r ReportJSON) ChangedResourceCount() int {
+	changed, _ := r.Metrics.Get("resources", "changed")
+	return int(changed)
+}
+
+// ReportFull holds a report together with its logs, metrics and events. The events are also set as
+// the data of the report's resource events.
+type ReportFull struct {
+	Report  ReportJSON
+	Logs    []PuppetReportMetricsLogEntry
+	Metrics []PuppetReportMetricsDataEntry
+	Events  []EventJSON
+}
+
 //Resource contains information about a puppet resource.
 type Resource struct {
 	Paramaters map[string]interface{} `json:"parameters"`
@@ -163,6 +473,14 @@ type ValueMetricJSON struct {
 	Value float64
 }
 
+// metricV2JSON A json object holding the jolokia response of the v2 metrics api. Jolokia answers
+// with http status 200 also for failed reads, the real status and error are in the body.
+type metricV2JSON struct {
+	Value  json.RawMessage `json:"value"`
+	Status int             `json:"status"`
+	Error  string          `json:"error"`
+}
+
 // getURL return the address of the puppetdb instance.
 func getURL(host string, port int, ssl bool) string {
 	if ssl {
@@ -172,120 +490,200 @@ func getURL(host string, port int, ssl bool) string {
 	}
 }
 
+// normalizeBaseURL removes the trailing slashes of a base url, so paths can be appended to it.
+func normalizeBaseURL(baseURL string) string {
+	return strings.TrimRight(baseURL, "/")
+}
+
 // NewClient returns a http connection for your puppetdb instance.
 func NewClient(host string, port int, verbose bool) *Client {
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
-	client := &http.Client{Transport: tr}
-	return &Client{getURL(host, port, false), "", "", client, verbose}
+	tr, _ := buildTransport(TLSOptions{Insecure: true})
+	client := &http.Client{Transport: tr, Timeout: DefaultTimeout}
+	return &Client{BaseURL: getURL(host, port, false), FollowRedirects: true, httpClient: client, verbose: verbose}
 }
 
 // NewClientURL returns a http connection for your puppetdb instance.
 func NewClientURL(url *url.URL, verbose bool) *Client {
-	client := &http.Client{}
-	return &Client{url.String(), "", "", client, verbose}
+	client := &http.Client{Timeout: DefaultTimeout}
+	return &Client{BaseURL: normalizeBaseURL(url.String()), FollowRedirects: true, httpClient: client, verbose: verbose}
 }
 
 // NewClientSSL returns a https connection for your puppetdb instance.
 func NewClientSSL(host string, port int, key string, cert string, ca string, verbose bool) *Client {
 	flag.Parse()
-	cert2, err := tls.LoadX509KeyPair(cert, key)
-	if err != nil {
-		log.Println(err.Error())
-	}
-	// Load CA cert
-	caCert, err := ioutil.ReadFile(ca)
+	transport, err := buildTransport(TLSOptions{Key: key, Cert: cert, CA: ca})
 	if err != nil {
 		log.Println(err.Error())
 	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-
-	// Setup HTTPS client
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert2},
-		RootCAs:      caCertPool,
-	}
-	tlsConfig.BuildNameToCertificate()
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
-	client := &http.Client{Transport: transport}
-	return &Client{getURL(host, port, true), cert, key, client, verbose}
+	client := &http.Client{Transport: transport, Timeout: DefaultTimeout}
+	return &Client{BaseURL: getURL(host, port, true), Cert: cert, Key: key, FollowRedirects: true, httpClient: client, verbose: verbose}
 
 }
 
 // NewClientSSLInsecure returns a https connection for your puppetdb instance but trusts self signed certificates.
 func NewClientSSLInsecure(host string, port int, verbose bool) *Client {
 	flag.Parse()
-	// Setup HTTPS client
-	tlsConfig := &tls.Config{
-
-		InsecureSkipVerify: true,
-	}
-	tlsConfig.BuildNameToCertificate()
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
-	client := &http.Client{Transport: transport}
-	return &Client{getURL(host, port, true), "", "", client, verbose}
+	transport, _ := buildTransport(TLSOptions{Insecure: true})
+	client := &http.Client{Transport: transport, Timeout: DefaultTimeout}
+	return &Client{BaseURL: getURL(host, port, true), FollowRedirects: true, httpClient: client, verbose: verbose}
 
 }
 
 // NewClientTimeout returns a http connection for your puppetdb instance with a timeout.
 func NewClientTimeout(host string, port int, verbose bool, timeout int) *Client {
 
-	tr := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	tr, _ := buildTransport(TLSOptions{Insecure: true})
 	client := &http.Client{Transport: tr, Timeout: time.Duration(timeout) * time.Second}
-	return &Client{getURL(host, port, false), "", "", client, verbose}
+	return &Client{BaseURL: getURL(host, port, false), FollowRedirects: true, httpClient: client, verbose: verbose}
 }
 
 // NewClientTimeoutSSL returns a http connection for your puppetdb instance with a timeout and ssl configured.
 func NewClientTimeoutSSL(host string, port int, key string, cert string, ca string, verbose bool, timeout int) *Client {
 	flag.Parse()
-	cert2, err := tls.LoadX509KeyPair(cert, key)
+	transport, err := buildTransport(TLSOptions{Key: key, Cert: cert, CA: ca})
 	if err != nil {
 		log.Println(err.Error())
 	}
-	// Load CA cert
-	caCert, err := ioutil.ReadFile(ca)
-	if err != nil {
-		log.Println(err.Error())
-	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-
-	// Setup HTTPS client
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert2},
-		RootCAs:      caCertPool,
-	}
-	tlsConfig.BuildNameToCertificate()
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
 	client := &http.Client{Transport: transport, Timeout: time.Duration(timeout) * time.Second}
-	return &Client{getURL(host, port, true), cert, key, client, verbose}
+	return &Client{BaseURL: getURL(host, port, true), Cert: cert, Key: key, FollowRedirects: true, httpClient: client, verbose: verbose}
+
+}
+
+// SetBaseURL sets the address of the puppetdb instance, removing trailing slashes. A path, e.g. of a
+// proxy, is kept. It returns the client so it can be chained with the constructors.
+func (c *Client) SetBaseURL(baseURL string) *Client {
+	c.BaseURL = normalizeBaseURL(baseURL)
+	return c
+}
+
+// SetTimeout sets the timeout of every request, a timeout of 0 disables it. It returns the client so
+// it can be chained with the constructors.
+func (c *Client) SetTimeout(timeout time.Duration) *Client {
+	c.httpClient.Timeout = timeout
+	return c
+}
+
+// SetUserAgent sets the User-Agent header sent with every request. It returns the client so it can
+// be chained with the constructors.
+func (c *Client) SetUserAgent(ua string) *Client {
+	c.userAgent = ua
+	return c
+}
+
+// SetBasicAuth sets the credentials used for http basic auth on every request, e.g. for a puppetdb
+// behind an authenticating proxy. It returns the client so it can be chained with the constructors.
+func (c *Client) SetBasicAuth(username string, password string) *Client {
+	c.username = username
+	c.password = password
+	return c
+}
 
+// SetToken sets the RBAC token sent in the X-Authentication header of every request. It can be
+// combined with the client certificate of NewClientSSL, e.g. NewClientSSL(...).SetToken(token).
+func (c *Client) SetToken(token string) *Client {
+	c.token = token
+	return c
 }
 
 // Get gets the given url and retruns the result. In form of the given interface.
 func (c *Client) Get(v interface{}, path string, params map[string]string) error {
-	pathAndParams := path
-	//TODO: Improve this
-	if params != nil && len(params) > 0 {
-		if !strings.Contains(path, "?") {
-			pathAndParams += "?"
-		}
-		for k, v := range params {
-			pathAndParams += fmt.Sprintf("%s=%s&", k, url.QueryEscape(v))
+	return c.GetContext(context.Background(), v, path, params)
+}
+
+// GetContext is like Get but the request is bound to the context. A context deadline acts as a
+// per query timeout; it can shorten but not extend the timeout of the http client.
+func (c *Client) GetContext(ctx context.Context, v interface{}, path string, params map[string]string) error {
+	pathAndParams := pathWithParams(path, params)
+	resp, err := c.httpGetContext(ctx, pathAndParams)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer resp.Body.Close()
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	err = c.decode(resp.Body, v)
+	if c.StrictDecode {
+		if err != nil {
+			return err
 		}
+		return checkRequired(v)
 	}
-	resp, err := c.httpGet(pathAndParams)
+	return nil
+}
+
+// From Posts the query against the given entity to the root query endpoint and decodes the result
+// into v. A nil query returns all entities.
+func (c *Client) From(entity string, query interface{}, v interface{}) error {
+	from := Query{"from", entity}
+	if query != nil {
+		from = append(from, query)
+	}
+	body, err := QueryToJSON(map[string]interface{}{"query": from})
+	if err != nil {
+		return err
+	}
+	path := "/pdb/query/v4"
+	resp, err := c.httpPostPath(context.Background(), path, []byte(body))
 	if err != nil {
 		log.Print(err)
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		contents, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, URL: resp.Request.URL.String(),
+			RequestBody: body, ResponseBody: strings.TrimSpace(string(contents))}
+	}
+	return c.decode(resp.Body, v)
+}
+
+// decode decodes a query result into v with the DecoderFunc of the client, or with json.Decoder if
+// none is set.
+func (c *Client) decode(r io.Reader, v interface{}) error {
+	if c.DecoderFunc != nil {
+		return c.DecoderFunc(r, v)
+	}
+	return json.NewDecoder(r).Decode(v)
+}
+
+// GetGabs gets the given url and returns the parsed but untyped result, which can be navigated with
+// the gabs paths. It is useful for endpoints without a typed method.
+func (c *Client) GetGabs(path string, params map[string]string) (*gabs.Container, error) {
+	resp, err := c.httpGet(pathWithParams(path, params))
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return gabs.ParseJSON(body)
+}
+
+// GetNDJSON gets the given url and writes every element of the resulting json array to w as a
+// single line of json. The response is streamed so memory use does not grow with the result size.
+func (c *Client) GetNDJSON(w io.Writer, path string, params map[string]string) error {
+	resp, err := c.httpGet(pathWithParams(path, params))
 	if err != nil {
 		log.Print(err)
 		return err
 	}
-	json.NewDecoder(resp.Body).Decode(&v)
-	return err
+	defer resp.Body.Close()
+	return streamArray(resp.Body, func(element json.RawMessage) error {
+		line := bytes.Buffer{}
+		err := json.Compact(&line, element)
+		if err != nil {
+			return err
+		}
+		line.WriteByte('\n')
+		_, err = w.Write(line.Bytes())
+		return err
+	})
 }
 
 // GetFacts returns an array of Json facts and returns them. It now uses gabs array because json value is not consistent.
@@ -326,139 +724,1455 @@ func (c *Client) GetFacts(path string) ([]FactJSON, error) {
 
 }
 
+// NodesIncludeDeactivated Polls the nodes api for the active, deactivated and expired nodes. Without a
+// node_state clause puppetdb only returns the active nodes, as Nodes does. Deactivated and expired nodes
+// have their Deactivated or Expired timestamp set.
+func (c *Client) NodesIncludeDeactivated() ([]NodeJSON, error) {
+	return c.queryNodes(Query{"=", "node_state", "any"})
+}
+
 // Nodes Polls the nodes api of your puppetdb and returns the results in form of the NodeJSON type.
+// Puppetdb only returns the active nodes, see NodesIncludeDeactivated.
 func (c *Client) Nodes() ([]NodeJSON, error) {
 	ret := []NodeJSON{}
 	err := c.Get(&ret, "nodes", nil)
 	return ret, err
 }
 
-// FactNames Gets all the fact names
-func (c *Client) FactNames() ([]string, error) {
-	ret := []string{}
-	err := c.Get(&ret, "fact-names", nil)
-	return ret, err
+// NodesWithoutFact Gets the nodes that do not have the specified fact.
+func (c *Client) NodesWithoutFact(factName string) ([]NodeJSON, error) {
+	return c.queryNodes(Query{"not", Query{"in", "certname",
+		Query{"extract", "certname", Query{"select_facts", Query{"=", "name", factName}}}}})
 }
 
-// NodeFacts Gets all the facts for a specified node.
-func (c *Client) NodeFacts(node string) ([]FactJSON, error) {
-	PUrl := fmt.Sprintf("nodes/%s/facts", node)
-	ret, err := c.GetFacts(PUrl)
-	return ret, err
+// NodesWithClass Gets the nodes whose catalog contains the specified class. Puppet stores class
+// titles with every namespace segment capitalized, so the name is capitalized the same way.
+func (c *Client) NodesWithClass(className string) ([]NodeJSON, error) {
+	return c.queryNodes(Query{"in", "certname", Query{"extract", "certname", Query{"select_resources",
+		Query{"and", Query{"=", "type", "Class"}, Query{"=", "title", classTitle(className)}}}}})
 }
 
-// FactPerNode Gets all nodes values for a specified fact.
-func (c *Client) FactPerNode(fact string) ([]FactJSON, error) {
-	PUrl := fmt.Sprintf("facts/%s", fact)
-	ret, err := c.GetFacts(PUrl)
-	return ret, err
+// NormalizeResource returns the resource with its type and title canonicalized, so the same resource
+// formatted differently on two nodes compares equal. The rules are:
+//   - surrounding whitespace is trimmed from the type and title
+//   - the type is capitalized per namespace segment, e.g. file becomes File
+//   - File titles are cleaned paths without trailing or duplicate slashes, e.g. /etc//ssh/ becomes /etc/ssh
+//   - Package titles are lowercased
+//   - Class titles are capitalized per namespace segment, e.g. profile::base becomes Profile::Base
+func NormalizeResource(r Resource) Resource {
+	r.Type = classTitle(strings.TrimSpace(r.Type))
+	r.Title = strings.TrimSpace(r.Title)
+	switch r.Type {
+	case "File":
+		if strings.HasPrefix(r.Title, "/") {
+			r.Title = path.Clean(r.Title)
+		}
+	case "Package":
+		r.Title = strings.ToLower(r.Title)
+	case "Class":
+		r.Title = classTitle(r.Title)
+	}
+	return r
 }
 
-// EventCounts Returns the even counts
-func (c *Client) EventCounts(query string, summarizeBy string, extraParams map[string]string) ([]EventCountJSON, error) {
-	path := "event-counts"
-	ret := []EventCountJSON{}
-	params := mergeParam("query", query, extraParams)
-	params = mergeParam("summarize-by", summarizeBy, params)
-	err := c.Get(&ret, path, params)
-	return ret, err
+// classTitle capitalizes every namespace segment of a class name, e.g. profile::base becomes
+// Profile::Base.
+func classTitle(className string) string {
+	segments := strings.Split(strings.TrimPrefix(className, "::"), "::")
+	for i, segment := range segments {
+		if segment != "" {
+			segments[i] = strings.ToUpper(segment[:1]) + segment[1:]
+		}
+	}
+	return strings.Join(segments, "::")
 }
 
-// Events returns the events
-func (c *Client) Events(query string, extraParams map[string]string) ([]EventJSON, error) {
-	path := "events"
-	ret := []EventJSON{}
-	params := mergeParam("query", query, extraParams)
-	err := c.Get(&ret, path, params)
-	return ret, err
+// ProblemNodes Gets the nodes whose latest report failed and the nodes without a report in the last
+// staleAfter, including nodes that never reported. A node matching both is returned once.
+func (c *Client) ProblemNodes(staleAfter time.Duration) ([]NodeJSON, error) {
+	failed, err := c.queryNodes(Query{"=", "latest_report_status", "failed"})
+	if err != nil {
+		return []NodeJSON{}, err
+	}
+	cutoff := queryTimestamp(now().Add(-staleAfter))
+	stale, err := c.queryNodes(Query{"or", Query{"<", "report_timestamp", cutoff},
+		Query{"null?", "report_timestamp", true}})
+	if err != nil {
+		return []NodeJSON{}, err
+	}
+	ret := []NodeJSON{}
+	seen := map[string]bool{}
+	for _, node := range append(failed, stale...) {
+		if !seen[node.Certname] {
+			seen[node.Certname] = true
+			ret = append(ret, node)
+		}
+	}
+	return ret, nil
 }
 
-//Resources will fetch resources from /resources/ in the puppetdb api
-func (c *Client) Resources(query string, extraParams map[string]string) ([]Resource, error) {
-	in := []Resource{}
-	params := mergeParam("query", query, extraParams)
-	err := c.Get(&in, "resources", params)
-	return in, err
+// NodesWithFactGreater Gets the nodes whose fact is greater than value. The comparison only matches
+// facts puppetdb stores as numbers; numeric values reported as strings are not compared.
+func (c *Client) NodesWithFactGreater(factName string, value float64) ([]NodeJSON, error) {
+	return c.queryNodes(Query{">", Query{"fact", factName}, value})
 }
 
-// Metric returns a metric
-func (c *Client) Metric(v interface{}, metric string) error {
-	PUrl := fmt.Sprintf("metrics/mbean/%s", metric)
-	err := c.Get(&v, PUrl, nil)
-	return err
+// NodesWithFactLess Gets the nodes whose fact is less than value. The comparison only matches facts
+// puppetdb stores as numbers; numeric values reported as strings are not compared.
+func (c *Client) NodesWithFactLess(factName string, value float64) ([]NodeJSON, error) {
+	return c.queryNodes(Query{"<", Query{"fact", factName}, value})
 }
 
-// MetricResourcesPerNode Gets the average resources per node
-func (c *Client) MetricResourcesPerNode() (result float64, err error) {
-	ret := ValueMetricJSON{}
-	return ret.Value, c.Metric(&ret, "com.puppetlabs.puppetdb.query.population:type=default,name=avg-resources-per-node")
+// queryNodes Polls the nodes api with the given query.
+func (c *Client) queryNodes(query interface{}) ([]NodeJSON, error) {
+	ret := []NodeJSON{}
+	q, err := QueryToJSON(query)
+	if err != nil {
+		return ret, err
+	}
+	err = c.Get(&ret, "nodes", mergeParam("query", q, nil))
+	return ret, err
 }
 
-// MetricNumResources Gets the number of resources
-func (c *Client) MetricNumResources() (result float64, err error) {
-	ret := ValueMetricJSON{}
-	return ret.Value, c.Metric(&ret, "com.puppetlabs.puppetdb.query.population:type=default,name=num-resources")
+// Certnames Gets the certnames of the nodes matching the query. An extract projection is used so only
+// the certnames are transferred.
+func (c *Client) Certnames(query string) ([]string, error) {
+	return c.certnames(context.Background(), query)
 }
 
-// MetricNumResources Gets the number of nodes
-func (c *Client) MetricNumNodes() (result float64, err error) {
-	ret := ValueMetricJSON{}
-	return ret.Value, c.Metric(&ret, "com.puppetlabs.puppetdb.query.population:type=default,name=num-nodes")
+func (c *Client) certnames(ctx context.Context, query string) ([]string, error) {
+	ret := []string{}
+	rows, err := c.extract(ctx, "nodes", []string{"certname"}, query)
+	for _, row := range rows {
+		if certname, ok := row["certname"].(string); ok {
+			ret = append(ret, certname)
+		}
+	}
+	return ret, err
 }
 
-// Reports Gets the reports with the specified querry.
-func (c *Client) Reports(query string, extraParams map[string]string) ([]ReportJSON, error) {
-	path := "reports"
-	ret := []ReportJSON{}
-	params := mergeParam("query", query, extraParams)
-	err := c.Get(&ret, path, params)
+// LatestReportStatuses Gets the status of the latest report of every node keyed on the certname. An
+// extract projection is used so only the certnames and statuses are transferred.
+func (c *Client) LatestReportStatuses() (map[string]string, error) {
+	ret := map[string]string{}
+	rows, err := c.extract(context.Background(), "nodes", []string{"certname", "latest_report_status"}, "")
+	for _, row := range rows {
+		certname, _ := row["certname"].(string)
+		status, _ := row["latest_report_status"].(string)
+		ret[certname] = status
+	}
 	return ret, err
 }
 
-// ReportByHash Gets the report for this specific hash
-func (c *Client) ReportByHash(hash string) ([]ReportJSON, error) {
-	path := fmt.Sprintf("reports")
-	ret := []ReportJSON{}
-	q := fmt.Sprintf("[\"=\", \"hash\", \"%s\"]", hash)
-	params := mergeParam("query", q, nil)
-
-	err := c.Get(&ret, path, params)
-	return ret, err
+// Extract Gets only the given fields of the entities of the endpoint matching the query.
+func (c *Client) Extract(endpoint string, fields []string, query string) ([]map[string]interface{}, error) {
+	return c.extract(context.Background(), endpoint, fields, query)
 }
 
-// PuppetdbVersion gets the specified puppetdb version.
-func (c *Client) PuppetdbVersion() (Version, error) {
-	path := "version"
-	ret := Version{}
-	err := c.Get(&ret, path, nil)
+func (c *Client) extract(ctx context.Context, endpoint string, fields []string, query string) ([]map[string]interface{}, error) {
+	ret := []map[string]interface{}{}
+	q, err := extractQuery(fields, query)
+	if err != nil {
+		return ret, err
+	}
+	err = c.GetContext(ctx, &ret, endpoint, mergeParam("query", q, nil))
 	return ret, err
 }
 
-// QueryToJSON Converts a query to json.
-func QueryToJSON(query interface{}) (result string, err error) {
-	resultBytes, err := json.Marshal(query)
-	jsonQuery := string(resultBytes[:])
-	return jsonQuery, err
+// Inventory Gets the nodes matching the query together with all their facts.
+func (c *Client) Inventory(query string) ([]InventoryJSON, error) {
+	ret := []InventoryJSON{}
+	err := c.Get(&ret, "inventory", mergeParam("query", query, nil))
+	return ret, err
 }
 
-func mergeParam(paramName string, paramValue string, params map[string]string) map[string]string {
-	resultParams := make(map[string]string)
-	if paramValue != "" {
-		resultParams[paramName] = paramValue
+// InventorySelect Gets the nodes matching the query with only the specified facts. An extract
+// projection is used so the other facts are not transferred. Facts a node does not have are missing
+// from its Facts.
+func (c *Client) InventorySelect(factNames []string, query string) ([]InventoryJSON, error) {
+	ret := []InventoryJSON{}
+	fields := []string{"certname"}
+	for _, name := range factNames {
+		fields = append(fields, "facts."+name)
 	}
-	if params != nil && len(params) > 0 {
-		for k, v := range params {
-			resultParams[k] = v
+	rows, err := c.extract(context.Background(), "inventory", fields, query)
+	if err != nil {
+		return ret, err
+	}
+	for _, row := range rows {
+		node := InventoryJSON{Facts: map[string]interface{}{}}
+		node.Certname, _ = row["certname"].(string)
+		for _, name := range factNames {
+			if value, ok := row["facts."+name]; ok && value != nil {
+				node.Facts[name] = value
+			}
 		}
+		ret = append(ret, node)
 	}
-	return resultParams
+	return ret, nil
 }
 
-func (c *Client) httpGet(endpoint string) (resp *http.Response, err error) {
-	base := strings.TrimRight(c.BaseURL, "/")
-	PUrl := fmt.Sprintf("%s/pdb/query/v4/%s", base, endpoint)
-	if c.verbose == true {
+// CountBy Gets the number of entities of the endpoint matching the query for each value of field.
+// The counting is done by puppetdb using the count function and group_by. Entities whose field is
+// null are counted under the empty string, so they are not lost from the totals.
+func (c *Client) CountBy(endpoint string, field string, query string) (map[string]int, error) {
+	ret := map[string]int{}
+	extract := []interface{}{"extract", []interface{}{[]string{"function", "count"}, field}}
+	if query != "" {
+		q, err := parseQuery(query)
+		if err != nil {
+			return ret, err
+		}
+		extract = append(extract, q)
+	}
+	extract = append(extract, []string{"group_by", field})
+	q, err := QueryToJSON(extract)
+	if err != nil {
+		return ret, err
+	}
+	rows := []map[string]interface{}{}
+	err = c.Get(&rows, endpoint, mergeParam("query", q, nil))
+	for _, row := range rows {
+		count, _ := row["count"].(float64)
+		key := ""
+		if row[field] != nil {
+			key = fmt.Sprint(row[field])
+		}
+		ret[key] += int(count)
+	}
+	return ret, err
+}
+
+// NodeCountByEnvironment Gets the number of active nodes for each catalog environment. The counting is
+// done by puppetdb, see CountBy. Nodes that never compiled a catalog are counted under the empty
+// string.
+func (c *Client) NodeCountByEnvironment() (map[string]int, error) {
+	return c.CountBy("nodes", "catalog_environment", "")
+}
+
+// ResourceTypes Gets the sorted distinct types of all resources. The types are grouped by puppetdb, so
+// the resources themselves are not transferred.
+func (c *Client) ResourceTypes() ([]string, error) {
+	ret := []string{}
+	q, err := QueryToJSON([]interface{}{"extract", []string{"type"}, []string{"group_by", "type"}})
+	if err != nil {
+		return ret, err
+	}
+	rows := []map[string]interface{}{}
+	err = c.Get(&rows, "resources", mergeParam("query", q, nil))
+	for _, row := range rows {
+		if t, ok := row["type"].(string); ok {
+			ret = append(ret, t)
+		}
+	}
+	sort.Strings(ret)
+	return ret, err
+}
+
+// CountNodes Gets the number of nodes matching the query. The total is taken from the X-Records header
+// of puppetdb, so at most a single node is transferred.
+func (c *Client) CountNodes(query string) (int, error) {
+	params := mergeParam("query", query, map[string]string{"include_total": "true", "limit": "1"})
+	resp, err := c.httpGet(pathWithParams("nodes", params))
+	if err != nil {
+		log.Print(err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, URL: resp.Request.URL.String()}
+	}
+	total := resp.Header.Get("X-Records")
+	if total == "" {
+		return 0, errors.New("No X-Records header in response")
+	}
+	return strconv.Atoi(total)
+}
+
+// NodeExists Gets whether an active node with the certname exists. It is counted with CountNodes, so
+// the node itself is not transferred. A missing node returns false without an error.
+func (c *Client) NodeExists(certname string) (bool, error) {
+	q, err := QueryToJSON(QueryCertname(certname))
+	if err != nil {
+		return false, err
+	}
+	count, err := c.CountNodes(q)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// CatalogAges Gets the age of the catalog of every node. Nodes without a catalog are not in the map.
+func (c *Client) CatalogAges() (map[string]time.Duration, error) {
+	ret := map[string]time.Duration{}
+	nodes, err := c.Nodes()
+	if err != nil {
+		return ret, err
+	}
+	for _, node := range nodes {
+		if node.CatalogTimestamp == "" {
+			continue
+		}
+		timestamp, err := time.Parse(time.RFC3339, node.CatalogTimestamp)
+		if err != nil {
+			return ret, err
+		}
+		ret[node.Certname] = now().Sub(timestamp)
+	}
+	return ret, nil
+}
+
+// NodesNDJSON Polls the nodes api of your puppetdb and writes the nodes to w as newline delimited json.
+func (c *Client) NodesNDJSON(w io.Writer) error {
+	return c.GetNDJSON(w, "nodes", nil)
+}
+
+// FactNames Gets all the fact names
+func (c *Client) FactNames() ([]string, error) {
+	ret := []string{}
+	err := c.Get(&ret, "fact-names", nil)
+	return ret, err
+}
+
+// NodeFacts Gets all the facts for a specified node.
+func (c *Client) NodeFacts(node string) ([]FactJSON, error) {
+	PUrl := fmt.Sprintf("nodes/%s/facts", url.PathEscape(node))
+	ret, err := c.GetFacts(PUrl)
+	return ret, err
+}
+
+// DiffNodeFacts Gets the facts of both nodes and returns the facts only one of them has and the facts
+// whose value differs. Structured facts are compared by their whole value.
+func (c *Client) DiffNodeFacts(certnameA string, certnameB string) (FactDiff, error) {
+	factsA, err := c.NodeFacts(certnameA)
+	if err != nil {
+		return FactDiff{}, err
+	}
+	factsB, err := c.NodeFacts(certnameB)
+	if err != nil {
+		return FactDiff{}, err
+	}
+	return diffFacts(factsA, factsB), nil
+}
+
+// NodeFactsFiltered Gets only the specified facts of a specific node.
+func (c *Client) NodeFactsFiltered(node string, names []string) ([]FactJSON, error) {
+	q, err := QueryToJSON(Query{"in", "name", Query{"array", names}})
+	if err != nil {
+		return []FactJSON{}, err
+	}
+	PUrl := fmt.Sprintf("nodes/%s/facts", url.PathEscape(node))
+	return c.GetFacts(pathWithParams(PUrl, mergeParam("query", q, nil)))
+}
+
+// NodeFactsNative Gets all facts for a specific node with their values decoded into native types.
+func (c *Client) NodeFactsNative(node string) ([]FactJSONNative, error) {
+	ret := []FactJSONNative{}
+	err := c.Get(&ret, fmt.Sprintf("nodes/%s/facts", url.PathEscape(node)), nil)
+	return ret, err
+}
+
+// FactsByNode Gets the facts matching the query as a map of the certnames to their fact values keyed
+// on the fact name.
+func (c *Client) FactsByNode(query string) (map[string]map[string]interface{}, error) {
+	ret := map[string]map[string]interface{}{}
+	facts := []FactJSONNative{}
+	err := c.Get(&facts, "facts", mergeParam("query", query, nil))
+	if err != nil {
+		return ret, err
+	}
+	for _, fact := range facts {
+		if ret[fact.CertName] == nil {
+			ret[fact.CertName] = map[string]interface{}{}
+		}
+		ret[fact.CertName][fact.Name] = fact.Value
+	}
+	return ret, nil
+}
+
+// FactPerNode Gets all nodes values for a specified fact.
+func (c *Client) FactPerNode(fact string) ([]FactJSON, error) {
+	PUrl := fmt.Sprintf("facts/%s", url.PathEscape(fact))
+	ret, err := c.GetFacts(PUrl)
+	return ret, err
+}
+
+// Catalog Gets the catalog of the specified node.
+func (c *Client) Catalog(certname string) (CatalogJSON, error) {
+	ret := CatalogJSON{}
+	err := c.Get(&ret, fmt.Sprintf("catalogs/%s", url.PathEscape(certname)), nil)
+	return ret, err
+}
+
+// CatalogByUUID Gets the catalog with this catalog uuid, which the report of the run using the catalog
+// also carries.
+func (c *Client) CatalogByUUID(uuid string) ([]CatalogJSON, error) {
+	ret := []CatalogJSON{}
+	q, err := QueryToJSON(Query{"=", "catalog_uuid", uuid})
+	if err != nil {
+		return ret, err
+	}
+	err = c.Get(&ret, "catalogs", mergeParam("query", q, nil))
+	return ret, err
+}
+
+// CatalogResources Gets the resources of the compiled catalog of the specified node.
+func (c *Client) CatalogResources(certname string) ([]Resource, error) {
+	ret := []Resource{}
+	err := c.Get(&ret, fmt.Sprintf("catalogs/%s/resources", url.PathEscape(certname)), nil)
+	return ret, err
+}
+
+// CatalogEdges Gets the edges of the compiled catalog of the specified node.
+func (c *Client) CatalogEdges(certname string) ([]EdgeJSON, error) {
+	ret := []EdgeJSON{}
+	err := c.Get(&ret, fmt.Sprintf("catalogs/%s/edges", url.PathEscape(certname)), nil)
+	return ret, err
+}
+
+// CatalogSummary Gets the catalog of the specified node and returns its number of resources and the
+// titles of its Class resources.
+func (c *Client) CatalogSummary(certname string) (CatalogSummaryJSON, error) {
+	ret := CatalogSummaryJSON{Classes: []string{}}
+	catalog, err := c.Catalog(certname)
+	if err != nil {
+		return ret, err
+	}
+	ret.ResourceCount = len(catalog.Resources.Data)
+	for _, r := range catalog.Resources.Data {
+		if r.Type == "Class" {
+			ret.Classes = append(ret.Classes, r.Title)
+		}
+	}
+	return ret, nil
+}
+
+// FactsForNodes Gets the specified facts of all specified nodes in a single request. An empty list
+// of certnames or fact names is not used to filter the facts.
+func (c *Client) FactsForNodes(certnames []string, factNames []string) ([]FactJSON, error) {
+	clauses := Query{"and"}
+	if len(certnames) > 0 {
+		clauses = append(clauses, Query{"in", "certname", Query{"array", certnames}})
+	}
+	if len(factNames) > 0 {
+		clauses = append(clauses, Query{"in", "name", Query{"array", factNames}})
+	}
+	if len(clauses) == 1 {
+		return c.GetFacts("facts")
+	}
+	q, err := QueryToJSON(clauses)
+	if err != nil {
+		return []FactJSON{}, err
+	}
+	return c.GetFacts(pathWithParams("facts", mergeParam("query", q, nil)))
+}
+
+// FactsChangedSince Gets the factsets of all nodes whose facts were produced after the given time.
+func (c *Client) FactsChangedSince(t time.Time) ([]FactsetJSON, error) {
+	ret := []FactsetJSON{}
+	q, err := QueryToJSON(Query{">", "producer_timestamp", queryTimestamp(t)})
+	if err != nil {
+		return ret, err
+	}
+	err = c.Get(&ret, "factsets", mergeParam("query", q, nil))
+	return ret, err
+}
+
+// EventCounts Returns the even counts
+func (c *Client) EventCounts(query string, summarizeBy string, extraParams map[string]string) ([]EventCountJSON, error) {
+	path := "event-counts"
+	ret := []EventCountJSON{}
+	params := mergeParam("query", query, extraParams)
+	params = mergeParam("summarize-by", summarizeBy, params)
+	err := c.Get(&ret, path, params)
+	return ret, err
+}
+
+// Events returns the events
+func (c *Client) Events(query string, extraParams map[string]string) ([]EventJSON, error) {
+	path := "events"
+	ret := []EventJSON{}
+	params := mergeParam("query", query, extraParams)
+	err := c.Get(&ret, path, params)
+	return ret, err
+}
+
+// EventsInWindow Gets the events from start until before end, combined with the specified query.
+func (c *Client) EventsInWindow(start time.Time, end time.Time, query string) ([]EventJSON, error) {
+	q, err := andQuery(query, Query{">=", "timestamp", queryTimestamp(start)},
+		Query{"<", "timestamp", queryTimestamp(end)})
+	if err != nil {
+		return []EventJSON{}, err
+	}
+	return c.Events(q, nil)
+}
+
+// LatestEventsPerResource Gets only the latest event of every resource matching the query. Puppetdb
+// requires a time window for distinct_resources, which is set to all events until now.
+func (c *Client) LatestEventsPerResource(query string) ([]EventJSON, error) {
+	return c.Events(query, map[string]string{
+		"distinct_resources":  "true",
+		"distinct_start_time": queryTimestamp(time.Unix(0, 0)),
+		"distinct_end_time":   queryTimestamp(now()),
+	})
+}
+
+// EventsStream Streams the events matching the query, paging through them eventsPageSize events at a
+// time ordered by timestamp, so memory use does not grow with the number of events. Both channels
+// are closed when the stream ends; at most one error is sent, after which no more events follow.
+func (c *Client) EventsStream(ctx context.Context, query string) (<-chan EventJSON, <-chan error) {
+	events := make(chan EventJSON)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(events)
+		for offset := 0; ; offset += eventsPageSize {
+			params := mergeParam("query", query, map[string]string{
+				"order_by": `[{"field":"timestamp","order":"asc"}]`,
+				"limit":    strconv.Itoa(eventsPageSize),
+				"offset":   strconv.Itoa(offset),
+			})
+			count, err := c.streamEvents(ctx, pathWithParams("events", params), events)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if count < eventsPageSize {
+				return
+			}
+		}
+	}()
+	return events, errs
+}
+
+// streamEvents sends the events of a single page to the channel and returns how many were sent.
+func (c *Client) streamEvents(ctx context.Context, pathAndParams string, events chan<- EventJSON) (int, error) {
+	resp, err := c.httpGetContext(ctx, pathAndParams)
+	if err != nil {
+		log.Print(err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+	count := 0
+	err = streamArray(resp.Body, func(element json.RawMessage) error {
+		event := EventJSON{}
+		err := json.Unmarshal(element, &event)
+		if err != nil {
+			return err
+		}
+		select {
+		case events <- event:
+			count++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	return count, err
+}
+
+//Resources will fetch resources from /resources/ in the puppetdb api
+func (c *Client) Resources(query string, extraParams map[string]string) ([]Resource, error) {
+	in := []Resource{}
+	params := mergeParam("query", query, extraParams)
+	err := c.Get(&in, "resources", params)
+	return in, err
+}
+
+// NodeResourcesByTypeMap Gets all resources of the node grouped by their type, e.g. File and Package.
+func (c *Client) NodeResourcesByTypeMap(certname string) (map[string][]Resource, error) {
+	ret := map[string][]Resource{}
+	resources := []Resource{}
+	err := c.Get(&resources, fmt.Sprintf("nodes/%s/resources", url.PathEscape(certname)), nil)
+	if err != nil {
+		return ret, err
+	}
+	for _, resource := range resources {
+		ret[resource.Type] = append(ret[resource.Type], resource)
+	}
+	return ret, nil
+}
+
+// ResourcesInEnvironment Gets the resources of the type on the nodes whose catalog was compiled in
+// the environment, using a subquery on the nodes.
+func (c *Client) ResourcesInEnvironment(resType string, env string) ([]Resource, error) {
+	q, err := QueryToJSON(And(Query{"=", "type", resType}, Query{"in", "certname",
+		Query{"extract", "certname", Query{"select_nodes", Query{"=", "catalog_environment", env}}}}))
+	if err != nil {
+		return []Resource{}, err
+	}
+	return c.Resources(q, nil)
+}
+
+// ResourcesByTag Gets the resources carrying the tag, combined with the specified query.
+func (c *Client) ResourcesByTag(tag string, query string) ([]Resource, error) {
+	q, err := andQuery(query, Query{"=", "tag", tag})
+	if err != nil {
+		return []Resource{}, err
+	}
+	return c.Resources(q, nil)
+}
+
+// ExportedResources Gets the exported resources of the type, combined with the specified query.
+// An empty type returns exported resources of all types.
+func (c *Client) ExportedResources(resType string, query string) ([]Resource, error) {
+	clauses := []interface{}{Query{"=", "exported", true}}
+	if resType != "" {
+		clauses = append(clauses, Query{"=", "type", resType})
+	}
+	q, err := andQuery(query, clauses...)
+	if err != nil {
+		return []Resource{}, err
+	}
+	return c.Resources(q, nil)
+}
+
+// metricV2Names maps the legacy mbean names of the metrics to their names in the v2 metrics api of
+// PuppetDB 6 and newer.
+var metricV2Names = map[string]string{
+	"com.puppetlabs.puppetdb.query.population:type=default,name=num-nodes":              "puppetlabs.puppetdb.population:name=num-nodes",
+	"com.puppetlabs.puppetdb.query.population:type=default,name=num-resources":          "puppetlabs.puppetdb.population:name=num-resources",
+	"com.puppetlabs.puppetdb.query.population:type=default,name=avg-resources-per-node": "puppetlabs.puppetdb.population:name=avg-resources-per-node",
+	"com.puppetlabs.puppetdb.query.population:type=default,name=pct-resource-dupes":     "puppetlabs.puppetdb.population:name=pct-resource-dupes",
+}
+
+// Metric returns a metric. PuppetDB 6 and newer are queried through the v2 (jolokia) metrics api,
+// older versions through the legacy mbean api. Legacy names of metrics that were renamed in the v2
+// api are translated, other names are used as is.
+func (c *Client) Metric(v interface{}, metric string) error {
+	if c.metricsVersion() >= 6 {
+		if name, ok := metricV2Names[metric]; ok {
+			metric = name
+		}
+		return c.metricV2(v, metric)
+	}
+	PUrl := fmt.Sprintf("metrics/mbean/%s", metric)
+	resp, err := c.httpGet(PUrl)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrMetricsDisabled
+	}
+	json.NewDecoder(resp.Body).Decode(&v)
+	return nil
+}
+
+// metricV2 reads a metric from the v2 metrics api and decodes the jolokia value into v.
+func (c *Client) metricV2(v interface{}, metric string) error {
+	resp, err := c.httpGetPath(fmt.Sprintf("/metrics/v2/read/%s", metric))
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, URL: resp.Request.URL.String()}
+	}
+	ret := metricV2JSON{}
+	err = json.NewDecoder(resp.Body).Decode(&ret)
+	if err != nil {
+		return err
+	}
+	if ret.Status != http.StatusOK {
+		return fmt.Errorf("Reading metric %s returned status %d: %s", metric, ret.Status, ret.Error)
+	}
+	return json.Unmarshal(ret.Value, v)
+}
+
+// MetricFloat returns the Value attribute of the mbean from the v2 metrics api, for metrics without a
+// method of their own.
+func (c *Client) MetricFloat(mbean string) (float64, error) {
+	ret := ValueMetricJSON{}
+	err := c.metricV2(&ret, mbean)
+	return ret.Value, err
+}
+
+// MetricInt is like MetricFloat for metrics counting something, e.g. the number of nodes.
+func (c *Client) MetricInt(mbean string) (int64, error) {
+	value, err := c.MetricFloat(mbean)
+	return int64(value), err
+}
+
+// metricsVersion returns the cached major version of puppetdb, detecting it on first use.
+// It returns 0 when the version could not be detected, which is cached as well so the legacy
+// metrics api is used without detecting the version again.
+func (c *Client) metricsVersion() int {
+	c.versionMutex.Lock()
+	detected, version := c.versionDetected, c.version
+	c.versionMutex.Unlock()
+	if detected {
+		return version
+	}
+	version, err := c.DetectVersion()
+	if err != nil {
+		log.Printf("Detecting the puppetdb version failed, using the legacy metrics api: %v", err)
+		c.versionMutex.Lock()
+		c.versionDetected = true
+		c.versionMutex.Unlock()
+	}
+	return version
+}
+
+// MetricResourcesPerNode Gets the average resources per node
+func (c *Client) MetricResourcesPerNode() (result float64, err error) {
+	ret := ValueMetricJSON{}
+	return ret.Value, c.Metric(&ret, "com.puppetlabs.puppetdb.query.population:type=default,name=avg-resources-per-node")
+}
+
+// MetricNumResources Gets the number of resources
+func (c *Client) MetricNumResources() (result float64, err error) {
+	ret := ValueMetricJSON{}
+	return ret.Value, c.Metric(&ret, "com.puppetlabs.puppetdb.query.population:type=default,name=num-resources")
+}
+
+// MetricNumResources Gets the number of nodes
+func (c *Client) MetricNumNodes() (result float64, err error) {
+	ret := ValueMetricJSON{}
+	return ret.Value, c.Metric(&ret, "com.puppetlabs.puppetdb.query.population:type=default,name=num-nodes")
+}
+
+// Reports Gets the reports with the specified querry.
+func (c *Client) Reports(query string, extraParams map[string]string) ([]ReportJSON, error) {
+	path := "reports"
+	ret := []ReportJSON{}
+	params := mergeParam("query", query, extraParams)
+	err := c.Get(&ret, path, params)
+	return ret, err
+}
+
+// ReportsForNodesWithFact Gets the reports of the nodes having the fact with the value, combined with
+// the specified query, e.g. the reports of all RedHat nodes.
+func (c *Client) ReportsForNodesWithFact(factName string, value interface{}, query string) ([]ReportJSON, error) {
+	q, err := andQuery(query, Query{"in", "certname", Query{"extract", "certname",
+		Query{"select_facts", And(Query{"=", "name", factName}, Query{"=", "value", value})}}})
+	if err != nil {
+		return []ReportJSON{}, err
+	}
+	return c.Reports(q, nil)
+}
+
+// ReportsSince Gets the reports received after the given time. Ordering by receive_time in the
+// extra params allows using the last receive time as a cursor for the next call.
+func (c *Client) ReportsSince(t time.Time, extraParams map[string]string) ([]ReportJSON, error) {
+	q, err := QueryToJSON(Query{">", "receive_time", queryTimestamp(t)})
+	if err != nil {
+		return []ReportJSON{}, err
+	}
+	return c.Reports(q, extraParams)
+}
+
+// ReportsAll Gets all the reports with the specified query by paging through them pageSize reports
+// at a time, ordered by receive time.
+func (c *Client) ReportsAll(query string, pageSize int) ([]ReportJSON, error) {
+	ret := []ReportJSON{}
+	if pageSize <= 0 {
+		return ret, errors.New("Page size must be positive")
+	}
+	for offset := 0; ; offset += pageSize {
+		page, err := c.Reports(query, map[string]string{
+			"order_by": `[{"field":"receive_time","order":"asc"}]`,
+			"limit":    strconv.Itoa(pageSize),
+			"offset":   strconv.Itoa(offset),
+		})
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, page...)
+		if len(page) < pageSize {
+			return ret, nil
+		}
+	}
+}
+
+// NodeDrift Gets the number of resources that would be changed according to the latest noop report
+// of the node. A node without noop reports has no drift.
+func (c *Client) NodeDrift(certname string) (int, error) {
+	q, err := QueryToJSON(Query{"and", Query{"=", "certname", certname}, Query{"=", "noop", true}})
+	if err != nil {
+		return 0, err
+	}
+	reports, err := c.Reports(q, map[string]string{
+		"order_by": `[{"field":"receive_time","order":"desc"}]`,
+		"limit":    "1",
+	})
+	if err != nil || len(reports) == 0 {
+		return 0, err
+	}
+	q, err = QueryToJSON(Query{"and", Query{"=", "report", reports[0].Hash}, Query{"=", "status", "noop"}})
+	if err != nil {
+		return 0, err
+	}
+	events, err := c.Events(q, nil)
+	return len(events), err
+}
+
+// ReportTimeRange Gets the receive time of the oldest and the newest report. Both are zero when
+// there are no reports.
+func (c *Client) ReportTimeRange() (oldest time.Time, newest time.Time, err error) {
+	oldest, err = c.reportReceiveTime("asc")
+	if err != nil {
+		return oldest, newest, err
+	}
+	newest, err = c.reportReceiveTime("desc")
+	return oldest, newest, err
+}
+
+// reportReceiveTime gets the receive time of the first report in the given order.
+func (c *Client) reportReceiveTime(order string) (time.Time, error) {
+	reports, err := c.Reports("", map[string]string{
+		"order_by": fmt.Sprintf(`[{"field":"receive_time","order":"%s"}]`, order),
+		"limit":    "1",
+	})
+	if err != nil || len(reports) == 0 {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, reports[0].ReceiveTime)
+}
+
+// ReportByHash Gets the report for this specific hash
+func (c *Client) ReportByHash(hash string) ([]ReportJSON, error) {
+	path := fmt.Sprintf("reports")
+	ret := []ReportJSON{}
+	q := fmt.Sprintf("[\"=\", \"hash\", \"%s\"]", hash)
+	params := mergeParam("query", q, nil)
+
+	err := c.Get(&ret, path, params)
+	return ret, err
+}
+
+// ReportByTransactionUUID Gets the report of the puppet run with this transaction uuid, which is also
+// logged when the catalog of the run is compiled.
+func (c *Client) ReportByTransactionUUID(uuid string) ([]ReportJSON, error) {
+	q, err := QueryToJSON(Query{"=", "transaction_uuid", uuid})
+	if err != nil {
+		return []ReportJSON{}, err
+	}
+	return c.Reports(q, nil)
+}
+
+// ReportSummary Gets the report for this specific hash and formats it as a single line with the
+// certname, status, number of changes and time of the run, e.g. for chat notifications.
+func (c *Client) ReportSummary(hash string) (string, error) {
+	reports, err := c.ReportByHash(hash)
+	if err != nil {
+		return "", err
+	}
+	if len(reports) == 0 {
+		return "", fmt.Errorf("Report %s not found", hash)
+	}
+	report := reports[0]
+	changes, _ := report.Metrics.Get("changes", "total")
+	summary := fmt.Sprintf("%s %s with %d changes at %s", report.CertName, report.Status, int(changes), report.EndTime)
+	if report.Noop {
+		summary += " (noop)"
+	}
+	return summary, nil
+}
+
+// ChangedReports Gets the reports of runs that changed resources, combined with the specified query.
+// Without includeNoop only enforced changes are returned, i.e. reports with status changed that are
+// not noop. With includeNoop the simulated changes of noop runs are returned as well, which puppet
+// reports with status unchanged and noop_pending set.
+func (c *Client) ChangedReports(includeNoop bool, query string) ([]ReportJSON, error) {
+	clauses := []interface{}{Query{"=", "status", "changed"}, Query{"=", "noop", false}}
+	if includeNoop {
+		clauses = []interface{}{Or(Query{"=", "status", "changed"}, Query{"=", "noop_pending", true})}
+	}
+	q, err := andQuery(query, clauses...)
+	if err != nil {
+		return []ReportJSON{}, err
+	}
+	return c.Reports(q, nil)
+}
+
+// DiffReports Gets the events of both reports and returns the resources whose events were added,
+// removed or changed from report hashA to report hashB.
+func (c *Client) DiffReports(hashA string, hashB string) (ReportDiff, error) {
+	ret := ReportDiff{}
+	eventsA, err := c.reportEvents(hashA)
+	if err != nil {
+		return ret, err
+	}
+	eventsB, err := c.reportEvents(hashB)
+	if err != nil {
+		return ret, err
+	}
+	return diffEvents(eventsA, eventsB), nil
+}
+
+// reportEvents gets the events of the report with the given hash.
+func (c *Client) reportEvents(hash string) ([]EventJSON, error) {
+	q, err := QueryToJSON([]string{"=", "report", hash})
+	if err != nil {
+		return []EventJSON{}, err
+	}
+	return c.Events(q, nil)
+}
+
+// ReportMetricValue Gets a single metric of the report with the given hash, e.g. category time and
+// name total for the run time. Only the metrics of the report are transferred.
+func (c *Client) ReportMetricValue(hash string, category string, name string) (float64, error) {
+	metrics := []PuppetReportMetricsDataEntry{}
+	err := c.Get(&metrics, fmt.Sprintf("reports/%s/metrics", url.PathEscape(hash)), nil)
+	if err != nil {
+		return 0, err
+	}
+	for _, metric := range metrics {
+		if metric.Category == category && metric.Name == name {
+			return metric.Value, nil
+		}
+	}
+	return 0, fmt.Errorf("Metric %s/%s not found in report %s", category, name, hash)
+}
+
+// ReportFull Gets the report for this specific hash and loads its logs, metrics and events.
+func (c *Client) ReportFull(hash string) (ReportFull, error) {
+	ret := ReportFull{}
+	reports, err := c.ReportByHash(hash)
+	if err != nil {
+		return ret, err
+	}
+	if len(reports) == 0 {
+		return ret, fmt.Errorf("Report %s not found", hash)
+	}
+	ret.Report = reports[0]
+	ret.Logs = []PuppetReportMetricsLogEntry{}
+	err = c.Get(&ret.Logs, fmt.Sprintf("reports/%s/logs", url.PathEscape(hash)), nil)
+	if err != nil {
+		return ret, err
+	}
+	ret.Metrics = []PuppetReportMetricsDataEntry{}
+	err = c.Get(&ret.Metrics, fmt.Sprintf("reports/%s/metrics", url.PathEscape(hash)), nil)
+	if err != nil {
+		return ret, err
+	}
+	ret.Events = []EventJSON{}
+	err = c.Get(&ret.Events, fmt.Sprintf("reports/%s/events", url.PathEscape(hash)), nil)
+	if ret.Events == nil {
+		ret.Events = []EventJSON{}
+	}
+	ret.Report.ResourceEvents.Data = ret.Events
+	return ret, err
+}
+
+// PuppetdbVersion gets the specified puppetdb version.
+func (c *Client) PuppetdbVersion() (Version, error) {
+	path := "version"
+	ret := Version{}
+	err := c.Get(&ret, path, nil)
+	return ret, err
+}
+
+// MetaVersion gets the puppetdb version from the meta api at /pdb/meta/v1/version, which is served
+// by PuppetDB 4 and newer.
+func (c *Client) MetaVersion() (Version, error) {
+	ret := Version{}
+	resp, err := c.httpGetPath("/pdb/meta/v1/version")
+	if err != nil {
+		log.Print(err)
+		return ret, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return ret, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, URL: resp.Request.URL.String()}
+	}
+	err = json.NewDecoder(resp.Body).Decode(&ret)
+	return ret, err
+}
+
+// DetectVersion gets the puppetdb version from the meta api and returns its major version. The
+// result is cached on the client and used to pick the matching metrics api.
+func (c *Client) DetectVersion() (major int, err error) {
+	version, err := c.MetaVersion()
+	if err != nil {
+		return 0, err
+	}
+	major, _, _, err = version.SemVer()
+	if err != nil {
+		return 0, err
+	}
+	c.versionMutex.Lock()
+	defer c.versionMutex.Unlock()
+	c.version = major
+	c.versionDetected = true
+	return major, nil
+}
+
+// SortNodesByReportTime sorts the nodes by their report timestamp. Nodes without a valid timestamp
+// are sorted last in both directions.
+func SortNodesByReportTime(nodes []NodeJSON, desc bool) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return timestampLess(nodes[i].ReportTimestamp, nodes[j].ReportTimestamp, desc)
+	})
+}
+
+// SortReportsByReceiveTime sorts the reports by their receive time. Reports without a valid
+// timestamp are sorted last in both directions.
+func SortReportsByReceiveTime(reports []ReportJSON, desc bool) {
+	sort.SliceStable(reports, func(i, j int) bool {
+		return timestampLess(reports[i].ReceiveTime, reports[j].ReceiveTime, desc)
+	})
+}
+
+// MergeReports concatenates pages of reports, dropping reports with a hash already seen on an earlier
+// page, so rows shifting between the requests of manual paging are not counted twice.
+func MergeReports(pages ...[]ReportJSON) []ReportJSON {
+	ret := []ReportJSON{}
+	seen := map[string]bool{}
+	for _, page := range pages {
+		for _, report := range page {
+			if seen[report.Hash] {
+				continue
+			}
+			seen[report.Hash] = true
+			ret = append(ret, report)
+		}
+	}
+	return ret
+}
+
+// MergeNodes concatenates pages of nodes, dropping nodes with a certname already seen, see
+// MergeReports.
+func MergeNodes(pages ...[]NodeJSON) []NodeJSON {
+	ret := []NodeJSON{}
+	seen := map[string]bool{}
+	for _, page := range pages {
+		for _, node := range page {
+			if seen[node.Certname] {
+				continue
+			}
+			seen[node.Certname] = true
+			ret = append(ret, node)
+		}
+	}
+	return ret
+}
+
+// MergeResources concatenates pages of resources, dropping resources with a hash already seen for the
+// same node, see MergeReports.
+func MergeResources(pages ...[]Resource) []Resource {
+	ret := []Resource{}
+	seen := map[string]bool{}
+	for _, page := range pages {
+		for _, resource := range page {
+			key := resource.Certname + "/" + resource.Resource
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ret = append(ret, resource)
+		}
+	}
+	return ret
+}
+
+// QueryToJSON Converts a query to json.
+func QueryToJSON(query interface{}) (result string, err error) {
+	// operators like < and > are kept as is instead of being escaped for html
+	buf := bytes.Buffer{}
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	err = encoder.Encode(query)
+	jsonQuery := strings.TrimSuffix(buf.String(), "\n")
+	return jsonQuery, err
+}
+
+// pathWithParams appends the url encoded params to the path.
+func pathWithParams(path string, params map[string]string) string {
+	pathAndParams := path
+	//TODO: Improve this
+	if params != nil && len(params) > 0 {
+		if !strings.Contains(path, "?") {
+			pathAndParams += "?"
+		}
+		for k, v := range params {
+			pathAndParams += fmt.Sprintf("%s=%s&", k, url.QueryEscape(v))
+		}
+	}
+	return pathAndParams
+}
+
+// streamArray decodes a json array from r one element at a time and calls fn for every element.
+func streamArray(r io.Reader, fn func(json.RawMessage) error) error {
+	dec := json.NewDecoder(r)
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := t.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("Expected a json array, got %v", t)
+	}
+	for dec.More() {
+		var element json.RawMessage
+		err = dec.Decode(&element)
+		if err != nil {
+			return err
+		}
+		err = fn(element)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// diffEvents compares two sets of events keyed on resource type, title and property.
+func diffEvents(eventsA []EventJSON, eventsB []EventJSON) ReportDiff {
+	ret := ReportDiff{}
+	byKey := map[string]EventJSON{}
+	for _, e := range eventsA {
+		byKey[eventKey(e)] = e
+	}
+	seen := map[string]bool{}
+	for _, e := range eventsB {
+		key := eventKey(e)
+		seen[key] = true
+		old, ok := byKey[key]
+		if !ok {
+			ret.Added = append(ret.Added, e)
+		} else if old.Status != e.Status ||
+			!reflect.DeepEqual(old.OldValue, e.OldValue) || !reflect.DeepEqual(old.NewValue, e.NewValue) {
+			ret.Changed = append(ret.Changed, EventChange{old, e})
+		}
+	}
+	for _, e := range eventsA {
+		if !seen[eventKey(e)] {
+			ret.Removed = append(ret.Removed, e)
+		}
+	}
+	return ret
+}
+
+// diffFacts compares two sets of facts keyed on the fact name.
+func diffFacts(factsA []FactJSON, factsB []FactJSON) FactDiff {
+	ret := FactDiff{}
+	byName := map[string]FactJSON{}
+	for _, f := range factsA {
+		byName[f.Name] = f
+	}
+	seen := map[string]bool{}
+	for _, f := range factsB {
+		seen[f.Name] = true
+		old, ok := byName[f.Name]
+		if !ok {
+			ret.OnlyB = append(ret.OnlyB, f)
+		} else if factValue(old) != factValue(f) {
+			ret.Changed = append(ret.Changed, FactChange{f.Name, old.Value, f.Value})
+		}
+	}
+	for _, f := range factsA {
+		if !seen[f.Name] {
+			ret.OnlyA = append(ret.OnlyA, f)
+		}
+	}
+	sort.Slice(ret.OnlyA, func(i, j int) bool { return ret.OnlyA[i].Name < ret.OnlyA[j].Name })
+	sort.Slice(ret.OnlyB, func(i, j int) bool { return ret.OnlyB[i].Name < ret.OnlyB[j].Name })
+	sort.Slice(ret.Changed, func(i, j int) bool { return ret.Changed[i].Name < ret.Changed[j].Name })
+	return ret
+}
+
+// factValue returns the json of the fact value. Object keys are marshalled in sorted order, so equal
+// structured values have equal json.
+func factValue(f FactJSON) string {
+	if f.Value == nil {
+		return "null"
+	}
+	return f.Value.String()
+}
+
+// eventKey returns the resource reference of an event together with its property, e.g.
+// File[/etc/motd].content.
+func eventKey(e EventJSON) string {
+	return fmt.Sprintf("%s[%s].%s", e.ResourceType, e.ResourceTitle, e.Property)
+}
+
+// timestampLess compares two RFC3339 timestamps, ordering empty or invalid timestamps last.
+func timestampLess(a string, b string, desc bool) bool {
+	timeA, errA := time.Parse(time.RFC3339, a)
+	timeB, errB := time.Parse(time.RFC3339, b)
+	switch {
+	case errA != nil:
+		return false
+	case errB != nil:
+		return true
+	case desc:
+		return timeA.After(timeB)
+	}
+	return timeA.Before(timeB)
+}
+
+// requiredChecker is implemented by results that can check their required fields for StrictDecode.
+type requiredChecker interface {
+	checkRequired() error
+}
+
+func (n NodeJSON) checkRequired() error {
+	if n.Certname == "" {
+		return errors.New("Node has no certname")
+	}
+	return nil
+}
+
+func (r ReportJSON) checkRequired() error {
+	if r.CertName == "" || r.Hash == "" {
+		return errors.New("Report has no certname or hash")
+	}
+	return nil
+}
+
+func (e EventJSON) checkRequired() error {
+	if e.CertName == "" {
+		return errors.New("Event has no certname")
+	}
+	return nil
+}
+
+func (r Resource) checkRequired() error {
+	if r.Type == "" || r.Title == "" {
+		return errors.New("Resource has no type or title")
+	}
+	return nil
+}
+
+func (c CatalogJSON) checkRequired() error {
+	if c.Certname == "" {
+		return errors.New("Catalog has no certname")
+	}
+	return nil
+}
+
+// checkRequired checks the required fields of a decoded result or of every element of a decoded list.
+func checkRequired(v interface{}) error {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Slice {
+		if checker, ok := value.Interface().(requiredChecker); ok {
+			return checker.checkRequired()
+		}
+		return nil
+	}
+	for i := 0; i < value.Len(); i++ {
+		if checker, ok := value.Index(i).Interface().(requiredChecker); ok {
+			err := checker.checkRequired()
+			if err != nil {
+				return fmt.Errorf("Result %d: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateRequestQuery checks the query of a request to the query api with ValidateQuery. The query
+// is the query param, or the query of a posted body. Queries that are not a json array, e.g. PQL
+// queries, are not checked.
+func validateRequestQuery(path string, body []byte) error {
+	if !strings.HasPrefix(path, "/pdb/query/") {
+		return nil
+	}
+	query := ""
+	if body != nil {
+		posted := struct {
+			Query json.RawMessage `json:"query"`
+		}{}
+		if json.Unmarshal(body, &posted) == nil {
+			query = string(posted.Query)
+		}
+	} else if u, err := url.Parse(path); err == nil {
+		query = u.Query().Get("query")
+	}
+	if !strings.HasPrefix(strings.TrimSpace(query), "[") {
+		return nil
+	}
+	return ValidateQuery(query)
+}
+
+// parseQuery parses a json query so it can be embedded in another query.
+func parseQuery(query string) (interface{}, error) {
+	var q interface{}
+	err := json.Unmarshal([]byte(query), &q)
+	return q, err
+}
+
+// andQuery combines the given json query with the clauses using the and operator.
+func andQuery(query string, clauses ...interface{}) (string, error) {
+	if query != "" {
+		q, err := parseQuery(query)
+		if err != nil {
+			return "", err
+		}
+		clauses = append(clauses, q)
+	}
+	switch len(clauses) {
+	case 0:
+		return "", nil
+	case 1:
+		return QueryToJSON(clauses[0])
+	}
+	return QueryToJSON(And(clauses...))
+}
+
+// queryTimestamp formats a time the way puppetdb expects timestamps in queries.
+func queryTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// extractQuery wraps the given json query in an extract projection of the fields. A single field is
+// extracted by name, several fields as an array.
+func extractQuery(fields []string, query string) (string, error) {
+	var extract []interface{}
+	switch len(fields) {
+	case 0:
+		return "", errors.New("No fields to extract")
+	case 1:
+		extract = []interface{}{"extract", fields[0]}
+	default:
+		extract = []interface{}{"extract", fields}
+	}
+	if query != "" {
+		q, err := parseQuery(query)
+		if err != nil {
+			return "", err
+		}
+		extract = append(extract, q)
+	}
+	return QueryToJSON(extract)
+}
+
+func mergeParam(paramName string, paramValue string, params map[string]string) map[string]string {
+	resultParams := make(map[string]string)
+	if paramValue != "" {
+		resultParams[paramName] = paramValue
+	}
+	if params != nil && len(params) > 0 {
+		for k, v := range params {
+			resultParams[k] = v
+		}
+	}
+	return resultParams
+}
+
+func (c *Client) httpGet(endpoint string) (resp *http.Response, err error) {
+	return c.httpGetContext(context.Background(), endpoint)
+}
+
+func (c *Client) httpGetContext(ctx context.Context, endpoint string) (resp *http.Response, err error) {
+	return c.httpDo(ctx, http.MethodGet, fmt.Sprintf("/pdb/query/v4/%s", endpoint), nil)
+}
+
+// httpGetPath gets the given path relative to the root of the puppetdb instance.
+func (c *Client) httpGetPath(path string) (resp *http.Response, err error) {
+	return c.httpDo(context.Background(), http.MethodGet, path, nil)
+}
+
+// httpPostPath posts the json body to the given path relative to the root of the puppetdb instance.
+func (c *Client) httpPostPath(ctx context.Context, path string, body []byte) (resp *http.Response, err error) {
+	return c.httpDo(ctx, http.MethodPost, path, body)
+}
+
+// httpDo sends a request with the configured headers to the given path relative to the root of the
+// puppetdb instance. A body is sent as json.
+func (c *Client) httpDo(ctx context.Context, method string, path string, body []byte) (resp *http.Response, err error) {
+	if c.ValidateQueries {
+		err = validateRequestQuery(path, body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.queryLog != nil {
+		sent := now()
+		start := time.Now()
+		defer func() {
+			c.queryLog.add(sent, path, body, time.Since(start))
+		}()
+	}
+	if c.pool != nil {
+		return c.pool.do(ctx, c, method, path, body)
+	}
+	return c.httpSend(ctx, method, c.BaseURL, path, body)
+}
+
+// httpSend sends a request with the configured headers to the given path of the base url.
+func (c *Client) httpSend(ctx context.Context, method string, baseURL string, path string, body []byte) (resp *http.Response, err error) {
+	base := normalizeBaseURL(baseURL)
+	PUrl := fmt.Sprintf("%s%s", base, path)
+	if c.verbose == true {
 		log.Printf(PUrl)
 	}
-	return c.httpClient.Get(PUrl)
+	if c.Trace != nil {
+		ctx = httptrace.WithClientTrace(ctx, c.Trace)
+	}
+	var bodyReader io.Reader
+	gzipped := false
+	if body != nil {
+		if c.GzipRequestThreshold > 0 && len(body) > c.GzipRequestThreshold {
+			body, err = gzipBody(body)
+			if err != nil {
+				return nil, err
+			}
+			gzipped = true
+		}
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, PUrl, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	c.setHeaders(req)
+	if c.FollowRedirects {
+		return c.httpClient.Do(req)
+	}
+	httpClient := *c.httpClient
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err = httpClient.Do(req)
+	if err == nil && resp.StatusCode >= 300 && resp.StatusCode <= 399 {
+		resp.Body.Close()
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, URL: PUrl, Location: resp.Header.Get("Location")}
+	}
+	return resp, err
+}
+
+// gzipBody returns the body compressed with gzip.
+func gzipBody(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(body)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setHeaders sets the headers configured on the client on the request.
+func (c *Client) setHeaders(req *http.Request) {
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	if c.token != "" {
+		req.Header.Set("X-Authentication", c.token)
+	}
 }
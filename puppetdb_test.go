@@ -1,14 +1,25 @@
 package puppetdb
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
 	"net/url"
+	"os"
 	"reflect"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/Jeffail/gabs"
 )
@@ -39,6 +50,16 @@ func testMethod(t *testing.T, r *http.Request, want string) {
 	}
 }
 
+func writePEM(t *testing.T, blockType string, b []byte) string {
+	f, err := ioutil.TempFile("", "puppetdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	pem.Encode(f, &pem.Block{Type: blockType, Bytes: b})
+	return f.Name()
+}
+
 func TestNodes(t *testing.T) {
 	setup()
 	defer teardown()
@@ -81,250 +102,2455 @@ func TestNodes(t *testing.T) {
 	}
 }
 
-func TestFactNames(t *testing.T) {
+func TestGetContextTimeout(t *testing.T) {
 	setup()
 	defer teardown()
 
-	mux.HandleFunc("/pdb/query/v4/fact-names",
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			fmt.Fprint(w, `[]`)
+		})
+
+	client.httpClient.Timeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	ret := []ReportJSON{}
+	err := client.GetContext(ctx, &ret, "reports", nil)
+	if err == nil {
+		t.Errorf("GetContext() returned no error after the context deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("GetContext() returned after %v, want the context deadline to apply", elapsed)
+	}
+}
+
+func TestStrictDecode(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
 		func(w http.ResponseWriter, r *http.Request) {
 			testMethod(t, r, "GET")
-			fmt.Fprint(w, `[ "fact1", "fact2", "fact3" ]`)
+			// v3 style response without a certname field
+			fmt.Fprint(w, `[{"name": "node1", "deactivated": null}]`)
 		})
 
-	facts, err := client.FactNames()
+	nodes, err := client.Nodes()
 	if err != nil {
-		t.Errorf("FactNames() returned error: %v", err)
+		t.Errorf("Nodes() returned error in lenient mode: %v", err)
 	}
-	want := []string{"fact1", "fact2", "fact3"}
-	if !reflect.DeepEqual(facts, want) {
-		t.Errorf("FactNames() returned %+v, want %+v",
-			facts, want)
+	if len(nodes) != 1 {
+		t.Errorf("Nodes() returned %+v in lenient mode", nodes)
+	}
+
+	client.StrictDecode = true
+	_, err = client.Nodes()
+	if err == nil {
+		t.Errorf("Nodes() returned no error in strict mode")
 	}
 }
 
-func TestNodeFacts(t *testing.T) {
+func TestRedirectNotFollowed(t *testing.T) {
 	setup()
 	defer teardown()
 
-	mux.HandleFunc("/pdb/query/v4/nodes/node123/facts",
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/login", http.StatusFound)
+		})
+	mux.HandleFunc("/login",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `<html>login</html>`)
+		})
+
+	client.FollowRedirects = false
+	_, err := client.Nodes()
+	httpErr, ok := err.(*HTTPError)
+	if !ok {
+		t.Fatalf("Nodes() returned error %v, want an *HTTPError", err)
+	}
+	if httpErr.StatusCode != http.StatusFound || httpErr.Location != "/login" {
+		t.Errorf("Nodes() returned %+v, want status 302 to /login", httpErr)
+	}
+}
+
+func TestBaseURLTrailingSlash(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/puppetdb/pdb/query/v4/nodes",
 		func(w http.ResponseWriter, r *http.Request) {
 			testMethod(t, r, "GET")
-			fmt.Fprint(w, `[{"certname" : "node123",
-			  				 "name" : "uptime_seconds",
-							   "value" : "9708003",
-							   "environment": "production"
-							}]`)
+			if r.RequestURI != "/puppetdb/pdb/query/v4/nodes" {
+				t.Errorf("Nodes() requested %s, want /puppetdb/pdb/query/v4/nodes", r.RequestURI)
+			}
+			fmt.Fprint(w, `[{"certname": "node1"}]`)
 		})
 
-	facts, err := client.NodeFacts("node123")
+	serverURL, _ := url.Parse(server.URL + "/puppetdb/")
+	client := NewClientURL(serverURL, false)
+	if client.BaseURL != server.URL+"/puppetdb" {
+		t.Errorf("NewClientURL() BaseURL = %s, want %s", client.BaseURL, server.URL+"/puppetdb")
+	}
+	if _, err := client.Nodes(); err != nil {
+		t.Errorf("Nodes() returned error: %v", err)
+	}
+
+	client = NewClient("localhost", 8080, false).SetBaseURL(server.URL + "/puppetdb//")
+	if client.BaseURL != server.URL+"/puppetdb" {
+		t.Errorf("SetBaseURL() BaseURL = %s, want %s", client.BaseURL, server.URL+"/puppetdb")
+	}
+	if _, err := client.Nodes(); err != nil {
+		t.Errorf("Nodes() returned error: %v", err)
+	}
+}
+
+func TestDefaultTimeout(t *testing.T) {
+	client := NewClient("localhost", 8080, false)
+	if client.httpClient.Timeout != DefaultTimeout {
+		t.Errorf("NewClient() timeout = %v, want %v", client.httpClient.Timeout, DefaultTimeout)
+	}
+	client.SetTimeout(5 * time.Second)
+	if client.httpClient.Timeout != 5*time.Second {
+		t.Errorf("SetTimeout() timeout = %v, want %v", client.httpClient.Timeout, 5*time.Second)
+	}
+	if timeout := NewClientURL(&url.URL{Scheme: "http", Host: "localhost:8080"}, false).httpClient.Timeout; timeout == 0 {
+		t.Errorf("NewClientURL() has no timeout")
+	}
+}
+
+func TestSetUserAgent(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.Header.Get("User-Agent"); got != "inventory-sync/1.0" {
+				t.Errorf("Request User-Agent = %q, want %q", got, "inventory-sync/1.0")
+			}
+			fmt.Fprint(w, `[]`)
+		})
+
+	client.SetUserAgent("inventory-sync/1.0")
+	_, err := client.Nodes()
 	if err != nil {
-		t.Errorf("NodesFacts() returned error: %v", err)
+		t.Errorf("Nodes() returned error: %v", err)
 	}
+}
 
-	jsonParsed, err := gabs.ParseJSON([]byte(`
-		"9708003"
+func TestTrace(t *testing.T) {
+	setup()
+	defer teardown()
 
-	`))
-	var want = []FactJSON{FactJSON{"node123", "production", "uptime_seconds", jsonParsed}}
-	if !reflect.DeepEqual(facts, want) {
-		t.Errorf("NodeFacts() returned %+v, want %+v",
-			facts, want)
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[]`)
+		})
+
+	gotConn := false
+	gotFirstByte := false
+	client.Trace = &httptrace.ClientTrace{
+		GotConn:              func(httptrace.GotConnInfo) { gotConn = true },
+		GotFirstResponseByte: func() { gotFirstByte = true },
+	}
+	_, err := client.Nodes()
+	if err != nil {
+		t.Errorf("Nodes() returned error: %v", err)
+	}
+	if !gotConn || !gotFirstByte {
+		t.Errorf("Trace hooks fired GotConn=%v GotFirstResponseByte=%v, want both", gotConn, gotFirstByte)
 	}
 }
 
-func TestMetricResourcesPerNode(t *testing.T) {
+func TestSetBasicAuth(t *testing.T) {
 	setup()
 	defer teardown()
 
-	mux.HandleFunc("/pdb/query/v4/metrics/mbean/com.puppetlabs.puppetdb.query.population:type=default,name=avg-resources-per-node",
+	mux.HandleFunc("/pdb/query/v4/nodes",
 		func(w http.ResponseWriter, r *http.Request) {
 			testMethod(t, r, "GET")
-			fmt.Fprint(w, `{"Value" : 309.130}`)
+			username, password, ok := r.BasicAuth()
+			if !ok || username != "puppet" || password != "secret" {
+				t.Errorf("Request basic auth = %q, %q, %v, want %q, %q, true",
+					username, password, ok, "puppet", "secret")
+			}
+			fmt.Fprint(w, `[]`)
 		})
 
-	value, err := client.MetricResourcesPerNode()
+	client.SetBasicAuth("puppet", "secret")
+	_, err := client.Nodes()
 	if err != nil {
 		t.Errorf("Nodes() returned error: %v", err)
 	}
+}
 
-	want := 309.130
-	if want != value {
-		t.Errorf("Nodes() returned %f, want %f",
-			value, want)
+func TestSSLClientSetToken(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.Header.Get("X-Authentication"); got != "rbac-token" {
+				t.Errorf("Request X-Authentication = %q, want %q", got, "rbac-token")
+			}
+			fmt.Fprint(w, `[]`)
+		}))
+	defer tlsServer.Close()
+
+	// The server certificate doubles as ca and client certificate.
+	keyBytes, err := x509.MarshalPKCS8PrivateKey(tlsServer.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := writePEM(t, "CERTIFICATE", tlsServer.Certificate().Raw)
+	defer os.Remove(cert)
+	key := writePEM(t, "PRIVATE KEY", keyBytes)
+	defer os.Remove(key)
+
+	serverURL, _ := url.Parse(tlsServer.URL)
+	splitsy := strings.Split(serverURL.Host, ":")
+	port, _ := strconv.Atoi(splitsy[1])
+	sslClient := NewClientSSL(splitsy[0], port, key, cert, cert, true).SetToken("rbac-token")
+
+	_, err = sslClient.Nodes()
+	if err != nil {
+		t.Errorf("Nodes() returned error: %v", err)
 	}
 }
 
-func TestPuppetdbVersion(t *testing.T) {
+func TestCatalogAges(t *testing.T) {
 	setup()
 	defer teardown()
+	now = func() time.Time { return time.Date(2019, 2, 19, 12, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
 
-	mux.HandleFunc("/pdb/query/v4/version",
+	mux.HandleFunc("/pdb/query/v4/nodes",
 		func(w http.ResponseWriter, r *http.Request) {
 			testMethod(t, r, "GET")
-			fmt.Fprint(w, `{ "version" : "2.2.0" },`)
+			fmt.Fprint(w, `[
+				{"certname": "fresh", "catalog_timestamp": "2019-02-19T11:30:00.000Z"},
+				{"certname": "stale", "catalog_timestamp": "2019-02-17T12:00:00.000Z"},
+				{"certname": "never-compiled", "catalog_timestamp": null}
+			]`)
 		})
 
-	facts, err := client.PuppetdbVersion()
+	ages, err := client.CatalogAges()
 	if err != nil {
-		t.Errorf("PuppetdbVersion() returned error: %v", err)
+		t.Errorf("CatalogAges() returned error: %v", err)
 	}
-	want := Version{"2.2.0"}
-	if !reflect.DeepEqual(facts, want) {
-		t.Errorf("PuppetdbVersion() returned %+v, want %+v",
-			facts, want)
+	want := map[string]time.Duration{"fresh": 30 * time.Minute, "stale": 48 * time.Hour}
+	if !reflect.DeepEqual(ages, want) {
+		t.Errorf("CatalogAges() returned %+v, want %+v", ages, want)
 	}
 }
 
-func TestNodeReports(t *testing.T) {
+func TestGetGabs(t *testing.T) {
 	setup()
 	defer teardown()
 
-	mux.HandleFunc("/pdb/query/v4/reports",
+	mux.HandleFunc("/pdb/query/v4/environments/production",
 		func(w http.ResponseWriter, r *http.Request) {
 			testMethod(t, r, "GET")
-			fmt.Fprint(w, `[{
-				"catalog_uuid": "3c77fcff-7e95-4129-a28f-af86566db52f",
-				"receive_time": "2019-02-19T13:27:21.312Z",
-				"producer": "puppet",
-				"hash": "ceb97994c50a968859dd44b90e14bb08e54e53ea",
-				"transaction_uuid": "005d2b4a-5a89-4096-9f81-ecc65f1e9082",
-				"puppet_version": "5.5.1",
-				"noop": false,
-				"corrective_change": null,
-				"logs": {
-					"data": [
-						{
-							"file": null,
-							"line": null,
-							"tags": [
-								"notice"
-							],
-							"time": "2019-02-19T14:27:21.032+01:00",
-							"level": "notice",
-							"source": "Puppet",
-							"message": "Applied catalog in 6.69 seconds"
-						}
-					],
-					"href": "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/logs"
-				},
-				"report_format": 9,
-				"start_time": "2019-02-19T13:27:04.740Z",
-				"producer_timestamp": "2019-02-19T13:27:21.282Z",
-				"cached_catalog_status": "not_used",
-				"end_time": "2019-02-19T13:27:20.973Z",
-				"resource_events": {
-					"data": null,
-					"href": "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/events"
-				},
-				"status": "unchanged",
-				"configuration_version": "1550582831",
-				"environment": "development",
-				"code_id": null,
-				"noop_pending": false,
-				"certname": "node",
-				"metrics": {
-					"data": [
-						{
-							"name": "changed",
-							"value": 0,
-							"category": "resources"
-						}
-					],
-					"href": "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/metrics"
-				},
-				"job_id": null
-			}]`)
+			fmt.Fprint(w, `{"name": "production", "settings": {"code": {"id": "abc123"}}}`)
 		})
 
-	facts, err := client.Reports("", nil)
+	container, err := client.GetGabs("environments/production", nil)
 	if err != nil {
-		t.Errorf("NodesReports() returned error: %v", err)
+		t.Errorf("GetGabs() returned error: %v", err)
 	}
-	want := []ReportJSON{ReportJSON{
-		CatalogUUID:          "3c77fcff-7e95-4129-a28f-af86566db52f",
-		ReceiveTime:          "2019-02-19T13:27:21.312Z",
-		Producer:             "puppet",
-		Hash:                 "ceb97994c50a968859dd44b90e14bb08e54e53ea",
-		TransactionUUID:      "005d2b4a-5a89-4096-9f81-ecc65f1e9082",
-		PuppetVersion:        "5.5.1",
-		Noop:                 false,
-		CorrectiveChange:     "",
-		ReportFormat:         9,
-		StartTime:            "2019-02-19T13:27:04.740Z",
-		ProducerTimestamp:    "2019-02-19T13:27:21.282Z",
-		CachedCatalogStatus:  "not_used",
-		EndTime:              "2019-02-19T13:27:20.973Z",
-		Status:               "unchanged",
-		ConfigurationVersion: "1550582831",
-		Environment:          "development",
-		CodeID:               "",
-		NoopPending:          false,
-		CertName:             "node",
-		ResourceEvents: PuppetReportResource{
-			Href: "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/events",
-		},
-		Logs: PuppetReportLog{
-			Data: []PuppetReportMetricsLogEntry{
-				PuppetReportMetricsLogEntry{
-					NewValue: "",
-					Property: "",
-					File:     "",
-					Line:     "",
-					Tags: []string{
-						"notice",
-					},
-					Time:    "2019-02-19T14:27:21.032+01:00",
-					Level:   "notice",
-					Source:  "Puppet",
-					Message: "Applied catalog in 6.69 seconds",
-				},
-			},
-			Href: "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/logs",
-		},
-		Metrics: PuppetReportMetrics{
-			Href: "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/metrics",
-			Data: []PuppetReportMetricsDataEntry{
-				PuppetReportMetricsDataEntry{
-					Name:     "changed",
-					Value:    0,
-					Category: "resources",
-				},
-			},
-		},
-	}}
-	if !reflect.DeepEqual(facts, want) {
-		t.Errorf("NodeReports() returned %+v, want %+v",
-			facts, want)
+	if id, ok := container.Path("settings.code.id").Data().(string); !ok || id != "abc123" {
+		t.Errorf("GetGabs() returned %s, want settings.code.id abc123", container.String())
 	}
 }
 
-func TestEventCounts(t *testing.T) {
+func TestNodesNDJSON(t *testing.T) {
 	setup()
 	defer teardown()
 
-	mux.HandleFunc("/pdb/query/v4/event-counts",
+	mux.HandleFunc("/pdb/query/v4/nodes",
 		func(w http.ResponseWriter, r *http.Request) {
 			testMethod(t, r, "GET")
-			fmt.Fprint(w, `[{
-				"subject" : {
-					"title" : "node123"
-				  },
-				 "subject-type" : "certname",
-				 "failures" : 0,
-				 "successes" : 1,
-				 "noops" : 0,
-				 "skips" : 0
-  			}]`)
+			fmt.Fprint(w, `[{"certname": "node1"},
+				{"certname": "node2"},
+				{"certname": "node3"}]`)
 		})
 
-	facts, err := client.EventCounts("", "certname", nil)
+	out := bytes.Buffer{}
+	err := client.NodesNDJSON(&out)
 	if err != nil {
-		t.Errorf("EventCount() returned error: %v", err)
+		t.Errorf("NodesNDJSON() returned error: %v", err)
 	}
-	want := []EventCountJSON{EventCountJSON{"certname", map[string]string{"title": "node123"}, 0, 1, 0, 0}}
-	if !reflect.DeepEqual(facts, want) {
-		t.Errorf("EventCount() returned %+v, want %+v",
-			facts, want)
+	want := "{\"certname\":\"node1\"}\n{\"certname\":\"node2\"}\n{\"certname\":\"node3\"}\n"
+	if out.String() != want {
+		t.Errorf("NodesNDJSON() wrote %q, want %q", out.String(), want)
+	}
+}
+
+func TestNodesWithoutFact(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["not",["in","certname",["extract","certname",["select_facts",["=","name","datacenter"]]]]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("NodesWithoutFact() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"certname": "node1"}]`)
+		})
+
+	nodes, err := client.NodesWithoutFact("datacenter")
+	if err != nil {
+		t.Errorf("NodesWithoutFact() returned error: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].Certname != "node1" {
+		t.Errorf("NodesWithoutFact() returned %+v", nodes)
+	}
+}
+
+func TestCountNodes(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.URL.Query().Get("include_total"); got != "true" {
+				t.Errorf("CountNodes() sent include_total %s, want true", got)
+			}
+			if got := r.URL.Query().Get("limit"); got != "1" {
+				t.Errorf("CountNodes() sent limit %s, want 1", got)
+			}
+			if got := r.URL.Query().Get("query"); got != `["=","report_environment","production"]` {
+				t.Errorf("CountNodes() sent query %s", got)
+			}
+			w.Header().Set("X-Records", "1342")
+			fmt.Fprint(w, `[{"certname": "node1"}]`)
+		})
+
+	count, err := client.CountNodes(`["=","report_environment","production"]`)
+	if err != nil {
+		t.Errorf("CountNodes() returned error: %v", err)
+	}
+	if count != 1342 {
+		t.Errorf("CountNodes() returned %d, want %d", count, 1342)
+	}
+}
+
+func TestNodeExists(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.URL.Query().Get("limit"); got != "1" {
+				t.Errorf("NodeExists() sent limit %s, want 1", got)
+			}
+			switch r.URL.Query().Get("query") {
+			case `["=","certname","node1"]`:
+				w.Header().Set("X-Records", "1")
+				fmt.Fprint(w, `[{"certname": "node1"}]`)
+			case `["=","certname","broken"]`:
+				http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+			default:
+				w.Header().Set("X-Records", "0")
+				fmt.Fprint(w, `[]`)
+			}
+		})
+
+	exists, err := client.NodeExists("node1")
+	if err != nil || !exists {
+		t.Errorf("NodeExists() returned %v, %v, want true, nil", exists, err)
+	}
+	exists, err = client.NodeExists("missing")
+	if err != nil || exists {
+		t.Errorf("NodeExists() returned %v, %v for a missing node, want false, nil", exists, err)
+	}
+	exists, err = client.NodeExists("broken")
+	if err == nil || exists {
+		t.Errorf("NodeExists() returned %v, %v for a failing puppetdb, want false and an error", exists, err)
+	}
+}
+
+func TestNodeCountByEnvironment(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["extract",[["function","count"],"catalog_environment"],["group_by","catalog_environment"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("NodeCountByEnvironment() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[
+				{"catalog_environment": "production", "count": 120},
+				{"catalog_environment": "staging", "count": 14},
+				{"catalog_environment": null, "count": 3}
+			]`)
+		})
+
+	counts, err := client.NodeCountByEnvironment()
+	if err != nil {
+		t.Errorf("NodeCountByEnvironment() returned error: %v", err)
+	}
+	want := map[string]int{"production": 120, "staging": 14, "": 3}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("NodeCountByEnvironment() returned %v, want %v", counts, want)
+	}
+}
+
+func TestNodesIncludeDeactivated(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if r.URL.Query().Get("query") == `["=","node_state","any"]` {
+				fmt.Fprint(w, `[
+					{"certname": "node1"},
+					{"certname": "node2", "deactivated": "2019-02-18T10:00:00.000Z"}
+				]`)
+				return
+			}
+			fmt.Fprint(w, `[{"certname": "node1"}]`)
+		})
+
+	active, err := client.Nodes()
+	if err != nil {
+		t.Errorf("Nodes() returned error: %v", err)
+	}
+	if len(active) != 1 {
+		t.Errorf("Nodes() returned %+v, want only the active node", active)
+	}
+	nodes, err := client.NodesIncludeDeactivated()
+	if err != nil {
+		t.Errorf("NodesIncludeDeactivated() returned error: %v", err)
+	}
+	want := []NodeJSON{{Certname: "node1"}, {Certname: "node2", Deactivated: "2019-02-18T10:00:00.000Z"}}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("NodesIncludeDeactivated() returned %+v, want %+v", nodes, want)
+	}
+}
+
+func TestNodesWithClass(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["in","certname",["extract","certname",["select_resources",["and",["=","type","Class"],["=","title","Profile::Webserver"]]]]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("NodesWithClass() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"certname": "web1"}]`)
+		})
+
+	nodes, err := client.NodesWithClass("profile::webserver")
+	if err != nil {
+		t.Errorf("NodesWithClass() returned error: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].Certname != "web1" {
+		t.Errorf("NodesWithClass() returned %+v", nodes)
+	}
+}
+
+func TestDecoderFunc(t *testing.T) {
+	setup()
+	defer teardown()
+
+	calls := 0
+	client.DecoderFunc = func(r io.Reader, v interface{}) error {
+		calls++
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		return dec.Decode(v)
+	}
+
+	mux.HandleFunc("/pdb/query/v4/facts",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{"certname": "node1", "name": "memorysize_mb", "value": 9007199254740993}]`)
+		})
+
+	rows := []map[string]interface{}{}
+	err := client.Get(&rows, "facts", nil)
+	if err != nil {
+		t.Errorf("Get() returned error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("DecoderFunc was called %d times, want 1", calls)
+	}
+	if len(rows) != 1 || rows[0]["value"] != json.Number("9007199254740993") {
+		t.Errorf("Get() returned %v, want the value decoded as a json.Number", rows)
+	}
+}
+
+func TestFrom(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "POST")
+			body, _ := ioutil.ReadAll(r.Body)
+			want := `{"query":["from","nodes",["=","report_environment","production"]]}`
+			if string(body) != want {
+				t.Errorf("From() posted %s, want %s", body, want)
+			}
+			if got := r.Header.Get("Content-Type"); got != "application/json" {
+				t.Errorf("From() sent content type %q", got)
+			}
+			fmt.Fprint(w, `[{"certname": "node1", "report_environment": "production"}]`)
+		})
+
+	nodes := []NodeJSON{}
+	err := client.From("nodes", Query{"=", "report_environment", "production"}, &nodes)
+	if err != nil {
+		t.Errorf("From() returned error: %v", err)
+	}
+	want := []NodeJSON{{Certname: "node1", ReportEnvironment: "production"}}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("From() returned %+v, want %+v", nodes, want)
+	}
+}
+
+func TestProblemNodes(t *testing.T) {
+	setup()
+	defer teardown()
+	now = func() time.Time { return time.Date(2019, 2, 19, 14, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			switch got := r.URL.Query().Get("query"); got {
+			case `["=","latest_report_status","failed"]`:
+				fmt.Fprint(w, `[{"certname": "failed1", "latest_report_status": "failed"},
+					{"certname": "both1", "latest_report_status": "failed"}]`)
+			case `["or",["<","report_timestamp","2019-02-19T12:00:00Z"],["null?","report_timestamp",true]]`:
+				fmt.Fprint(w, `[{"certname": "stale1", "latest_report_status": "changed"},
+					{"certname": "both1", "latest_report_status": "failed"}]`)
+			default:
+				t.Errorf("ProblemNodes() sent query %s", got)
+			}
+		})
+
+	nodes, err := client.ProblemNodes(2 * time.Hour)
+	if err != nil {
+		t.Errorf("ProblemNodes() returned error: %v", err)
+	}
+	certnames := []string{}
+	for _, node := range nodes {
+		certnames = append(certnames, node.Certname)
+	}
+	want := []string{"failed1", "both1", "stale1"}
+	if !reflect.DeepEqual(certnames, want) {
+		t.Errorf("ProblemNodes() returned %v, want %v", certnames, want)
+	}
+}
+
+func TestResourcesInEnvironment(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/resources",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["and",["=","type","Service"],["in","certname",["extract","certname",["select_nodes",["=","catalog_environment","production"]]]]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("ResourcesInEnvironment() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"certname": "node1", "type": "Service", "title": "sshd"}]`)
+		})
+
+	resources, err := client.ResourcesInEnvironment("Service", "production")
+	if err != nil {
+		t.Errorf("ResourcesInEnvironment() returned error: %v", err)
+	}
+	want := []Resource{{Certname: "node1", Type: "Service", Title: "sshd"}}
+	if !reflect.DeepEqual(resources, want) {
+		t.Errorf("ResourcesInEnvironment() returned %+v, want %+v", resources, want)
+	}
+}
+
+func TestNodeResourcesByTypeMap(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes/node1/resources",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[
+				{"certname": "node1", "type": "File", "title": "/etc/motd"},
+				{"certname": "node1", "type": "Package", "title": "openssl"},
+				{"certname": "node1", "type": "File", "title": "/etc/hosts"}
+			]`)
+		})
+
+	resources, err := client.NodeResourcesByTypeMap("node1")
+	if err != nil {
+		t.Errorf("NodeResourcesByTypeMap() returned error: %v", err)
+	}
+	want := map[string][]Resource{
+		"File": {
+			{Certname: "node1", Type: "File", Title: "/etc/motd"},
+			{Certname: "node1", Type: "File", Title: "/etc/hosts"},
+		},
+		"Package": {{Certname: "node1", Type: "Package", Title: "openssl"}},
+	}
+	if !reflect.DeepEqual(resources, want) {
+		t.Errorf("NodeResourcesByTypeMap() returned %+v, want %+v", resources, want)
+	}
+}
+
+func TestNormalizeResource(t *testing.T) {
+	a := NormalizeResource(Resource{Type: "file", Title: "/etc//ssh/"})
+	b := NormalizeResource(Resource{Type: "File", Title: "/etc/ssh"})
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("NormalizeResource() returned %+v and %+v, want equal", a, b)
+	}
+	tests := []struct {
+		resource Resource
+		want     Resource
+	}{
+		{Resource{Type: "package", Title: "OpenSSL "}, Resource{Type: "Package", Title: "openssl"}},
+		{Resource{Type: "class", Title: "profile::base"}, Resource{Type: "Class", Title: "Profile::Base"}},
+		{Resource{Type: "File", Title: "/"}, Resource{Type: "File", Title: "/"}},
+		{Resource{Type: "Service", Title: "sshd"}, Resource{Type: "Service", Title: "sshd"}},
+	}
+	for _, tt := range tests {
+		if got := NormalizeResource(tt.resource); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("NormalizeResource(%+v) returned %+v, want %+v", tt.resource, got, tt.want)
+		}
+	}
+}
+
+func TestFromGzipRequest(t *testing.T) {
+	setup()
+	defer teardown()
+	client.GzipRequestThreshold = 1024
+
+	certnames := []interface{}{"array"}
+	for i := 0; i < 1000; i++ {
+		certnames = append(certnames, fmt.Sprintf("node%d.example.com", i))
+	}
+	query := Query{"in", "certname", certnames}
+	want, _ := QueryToJSON(map[string]interface{}{"query": Query{"from", "nodes", query}})
+
+	mux.HandleFunc("/pdb/query/v4",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "POST")
+			if got := r.Header.Get("Content-Encoding"); got != "gzip" {
+				t.Errorf("From() sent Content-Encoding %q, want gzip", got)
+			}
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Fatalf("From() posted a body that is not gzipped: %v", err)
+			}
+			body, _ := ioutil.ReadAll(zr)
+			if string(body) != want {
+				t.Errorf("From() posted %s, want %s", body, want)
+			}
+			fmt.Fprint(w, `[]`)
+		})
+
+	nodes := []NodeJSON{}
+	err := client.From("nodes", query, &nodes)
+	if err != nil {
+		t.Errorf("From() returned error: %v", err)
+	}
+}
+
+func TestFromBadRequest(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "POST")
+			http.Error(w, "'==' is not a valid operator", http.StatusBadRequest)
+		})
+
+	nodes := []NodeJSON{}
+	err := client.From("nodes", Query{"==", "certname", "node1"}, &nodes)
+	httpErr, ok := err.(*HTTPError)
+	if !ok {
+		t.Fatalf("From() returned error %v, want *HTTPError", err)
+	}
+	want := `{"query":["from","nodes",["==","certname","node1"]]}`
+	if httpErr.StatusCode != http.StatusBadRequest || httpErr.RequestBody != want {
+		t.Errorf("From() returned %+v, want status 400 and request body %s", httpErr, want)
+	}
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("From() returned error %q, want it to contain %s", err.Error(), want)
+	}
+	wantResponse := "'==' is not a valid operator"
+	if httpErr.ResponseBody != wantResponse {
+		t.Errorf("From() returned response body %q, want %q", httpErr.ResponseBody, wantResponse)
+	}
+	if !strings.Contains(err.Error(), wantResponse) {
+		t.Errorf("From() returned error %q, want it to contain %s", err.Error(), wantResponse)
+	}
+}
+
+func TestNodesWithFactComparison(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var gotQuery string
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			gotQuery = r.URL.Query().Get("query")
+			fmt.Fprint(w, `[{"certname": "node1"}]`)
+		})
+
+	tests := []struct {
+		name  string
+		query func(string, float64) ([]NodeJSON, error)
+		want  string
+	}{
+		{"NodesWithFactGreater", client.NodesWithFactGreater, `[">",["fact","uptime_seconds"],86400]`},
+		{"NodesWithFactLess", client.NodesWithFactLess, `["<",["fact","uptime_seconds"],86400]`},
+	}
+	for _, tt := range tests {
+		nodes, err := tt.query("uptime_seconds", 86400)
+		if err != nil {
+			t.Errorf("%s() returned error: %v", tt.name, err)
+		}
+		if gotQuery != tt.want {
+			t.Errorf("%s() sent query %s, want %s", tt.name, gotQuery, tt.want)
+		}
+		if len(nodes) != 1 || nodes[0].Certname != "node1" {
+			t.Errorf("%s() returned %+v", tt.name, nodes)
+		}
+	}
+}
+
+func TestCertnames(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["extract","certname",["=","catalog_environment","production"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("Certnames() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"certname": "node1"}, {"certname": "node2"}]`)
+		})
+
+	certnames, err := client.Certnames(`["=","catalog_environment","production"]`)
+	if err != nil {
+		t.Errorf("Certnames() returned error: %v", err)
+	}
+	want := []string{"node1", "node2"}
+	if !reflect.DeepEqual(certnames, want) {
+		t.Errorf("Certnames() returned %+v, want %+v",
+			certnames, want)
+	}
+}
+
+func TestLatestReportStatuses(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["extract",["certname","latest_report_status"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("LatestReportStatuses() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[
+				{"certname": "node1", "latest_report_status": "unchanged"},
+				{"certname": "node2", "latest_report_status": "failed"},
+				{"certname": "node3", "latest_report_status": null}
+			]`)
+		})
+
+	statuses, err := client.LatestReportStatuses()
+	if err != nil {
+		t.Errorf("LatestReportStatuses() returned error: %v", err)
+	}
+	want := map[string]string{"node1": "unchanged", "node2": "failed", "node3": ""}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("LatestReportStatuses() returned %v, want %v", statuses, want)
+	}
+}
+
+func TestInventorySelect(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/inventory",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["extract",["certname","facts.kernel","facts.os.family"],["=","environment","production"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("InventorySelect() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[
+				{"certname": "node1", "facts.kernel": "Linux", "facts.os.family": "RedHat"},
+				{"certname": "node2", "facts.kernel": "windows", "facts.os.family": null}
+			]`)
+		})
+
+	nodes, err := client.InventorySelect([]string{"kernel", "os.family"}, `["=","environment","production"]`)
+	if err != nil {
+		t.Errorf("InventorySelect() returned error: %v", err)
+	}
+	want := []InventoryJSON{
+		{Certname: "node1", Facts: map[string]interface{}{"kernel": "Linux", "os.family": "RedHat"}},
+		{Certname: "node2", Facts: map[string]interface{}{"kernel": "windows"}},
+	}
+	if !reflect.DeepEqual(nodes, want) {
+		t.Errorf("InventorySelect() returned %+v, want %+v", nodes, want)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	setup()
+	defer teardown()
+
+	var gotQuery string
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			gotQuery = r.URL.Query().Get("query")
+			fmt.Fprint(w, `[{"certname": "node1", "latest_report_status": "failed"}]`)
+		})
+
+	tests := []struct {
+		fields []string
+		query  string
+		want   string
+	}{
+		{[]string{"certname"}, "", `["extract","certname"]`},
+		{[]string{"certname", "latest_report_status"}, `["=","certname","node1"]`,
+			`["extract",["certname","latest_report_status"],["=","certname","node1"]]`},
+	}
+	for _, tt := range tests {
+		rows, err := client.Extract("nodes", tt.fields, tt.query)
+		if err != nil {
+			t.Errorf("Extract() returned error: %v", err)
+		}
+		if gotQuery != tt.want {
+			t.Errorf("Extract(%v) sent query %s, want %s", tt.fields, gotQuery, tt.want)
+		}
+		want := []map[string]interface{}{{"certname": "node1", "latest_report_status": "failed"}}
+		if !reflect.DeepEqual(rows, want) {
+			t.Errorf("Extract(%v) returned %+v, want %+v", tt.fields, rows, want)
+		}
+	}
+}
+
+func TestCountBy(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["extract",[["function","count"],"latest_report_status"],["=","catalog_environment","production"],["group_by","latest_report_status"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("CountBy() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"count": 12, "latest_report_status": "unchanged"},
+				{"count": 3, "latest_report_status": "failed"}]`)
+		})
+
+	counts, err := client.CountBy("nodes", "latest_report_status", `["=","catalog_environment","production"]`)
+	if err != nil {
+		t.Errorf("CountBy() returned error: %v", err)
+	}
+	want := map[string]int{"unchanged": 12, "failed": 3}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("CountBy() returned %+v, want %+v",
+			counts, want)
+	}
+}
+
+func TestFactNames(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/fact-names",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[ "fact1", "fact2", "fact3" ]`)
+		})
+
+	facts, err := client.FactNames()
+	if err != nil {
+		t.Errorf("FactNames() returned error: %v", err)
+	}
+	want := []string{"fact1", "fact2", "fact3"}
+	if !reflect.DeepEqual(facts, want) {
+		t.Errorf("FactNames() returned %+v, want %+v",
+			facts, want)
+	}
+}
+
+func TestNodeFacts(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes/node123/facts",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{"certname" : "node123",
+			  				 "name" : "uptime_seconds",
+							   "value" : "9708003",
+							   "environment": "production"
+							}]`)
+		})
+
+	facts, err := client.NodeFacts("node123")
+	if err != nil {
+		t.Errorf("NodesFacts() returned error: %v", err)
+	}
+
+	jsonParsed, err := gabs.ParseJSON([]byte(`
+		"9708003"
+
+	`))
+	var want = []FactJSON{FactJSON{"node123", "production", "uptime_seconds", jsonParsed}}
+	if !reflect.DeepEqual(facts, want) {
+		t.Errorf("NodeFacts() returned %+v, want %+v",
+			facts, want)
+	}
+}
+
+func TestFactsForNodes(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/facts",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["and",["in","certname",["array",["node1","node2"]]],["in","name",["array",["osfamily"]]]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("FactsForNodes() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[
+				{"certname": "node1", "name": "osfamily", "value": "RedHat", "environment": "production"},
+				{"certname": "node2", "name": "osfamily", "value": "Debian", "environment": "production"}
+			]`)
+		})
+
+	facts, err := client.FactsForNodes([]string{"node1", "node2"}, []string{"osfamily"})
+	if err != nil {
+		t.Errorf("FactsForNodes() returned error: %v", err)
+	}
+	redhat, _ := gabs.ParseJSON([]byte(`"RedHat"`))
+	debian, _ := gabs.ParseJSON([]byte(`"Debian"`))
+	want := []FactJSON{
+		{"node1", "production", "osfamily", redhat},
+		{"node2", "production", "osfamily", debian},
+	}
+	if !reflect.DeepEqual(facts, want) {
+		t.Errorf("FactsForNodes() returned %+v, want %+v", facts, want)
+	}
+}
+
+func TestFactValueType(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{`"RedHat"`, "string"},
+		{`86400`, "number"},
+		{`1.5`, "number"},
+		{`true`, "boolean"},
+		{`["eth0", "lo"]`, "array"},
+		{`{"family": "RedHat"}`, "map"},
+		{`null`, "null"},
+	}
+	for _, tt := range tests {
+		value, err := gabs.ParseJSON([]byte(tt.value))
+		if err != nil {
+			t.Fatalf("ParseJSON(%s) returned error: %v", tt.value, err)
+		}
+		if got := (FactJSON{Value: value}).ValueType(); got != tt.want {
+			t.Errorf("ValueType() of %s returned %s, want %s", tt.value, got, tt.want)
+		}
+	}
+	if got := (FactJSON{}).ValueType(); got != "null" {
+		t.Errorf("ValueType() without value returned %s, want null", got)
+	}
+}
+
+func TestNodeFactsFiltered(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes/node1/facts",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["in","name",["array",["kernel","osfamily"]]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("NodeFactsFiltered() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[
+				{"certname": "node1", "environment": "production", "name": "kernel", "value": "Linux"},
+				{"certname": "node1", "environment": "production", "name": "osfamily", "value": "RedHat"}
+			]`)
+		})
+
+	facts, err := client.NodeFactsFiltered("node1", []string{"kernel", "osfamily"})
+	if err != nil {
+		t.Errorf("NodeFactsFiltered() returned error: %v", err)
+	}
+	names := []string{}
+	for _, fact := range facts {
+		names = append(names, fact.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"kernel", "osfamily"}) {
+		t.Errorf("NodeFactsFiltered() returned facts %v, want kernel and osfamily", names)
+	}
+}
+
+func TestResourceTypes(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/resources",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.URL.Query().Get("query"); got != `["extract",["type"],["group_by","type"]]` {
+				t.Errorf("ResourceTypes() sent query %s", got)
+			}
+			fmt.Fprint(w, `[{"type": "Service"}, {"type": "File"}, {"type": "Package"}]`)
+		})
+
+	types, err := client.ResourceTypes()
+	if err != nil {
+		t.Errorf("ResourceTypes() returned error: %v", err)
+	}
+	want := []string{"File", "Package", "Service"}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("ResourceTypes() returned %v, want %v", types, want)
+	}
+}
+
+func TestFactsByNode(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/facts",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.URL.Query().Get("query"); got != `["in","name",["array",["kernel","processorcount"]]]` {
+				t.Errorf("FactsByNode() sent query %s", got)
+			}
+			fmt.Fprint(w, `[
+				{"certname": "node1", "environment": "production", "name": "kernel", "value": "Linux"},
+				{"certname": "node1", "environment": "production", "name": "processorcount", "value": 4},
+				{"certname": "node2", "environment": "production", "name": "kernel", "value": "windows"},
+				{"certname": "node2", "environment": "production", "name": "processorcount", "value": 2}
+			]`)
+		})
+
+	facts, err := client.FactsByNode(`["in","name",["array",["kernel","processorcount"]]]`)
+	if err != nil {
+		t.Errorf("FactsByNode() returned error: %v", err)
+	}
+	want := map[string]map[string]interface{}{
+		"node1": {"kernel": "Linux", "processorcount": float64(4)},
+		"node2": {"kernel": "windows", "processorcount": float64(2)},
+	}
+	if !reflect.DeepEqual(facts, want) {
+		t.Errorf("FactsByNode() returned %v, want %v", facts, want)
+	}
+}
+
+func TestNodeFactsNative(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes/node1/facts",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[
+				{"certname": "node1", "environment": "production", "name": "kernel", "value": "Linux"},
+				{"certname": "node1", "environment": "production", "name": "processorcount", "value": 4},
+				{"certname": "node1", "environment": "production", "name": "os", "value": {"family": "RedHat", "release": {"major": "7"}}}
+			]`)
+		})
+
+	facts, err := client.NodeFactsNative("node1")
+	if err != nil {
+		t.Errorf("NodeFactsNative() returned error: %v", err)
+	}
+	want := []FactJSONNative{
+		{"node1", "production", "kernel", "Linux"},
+		{"node1", "production", "processorcount", float64(4)},
+		{"node1", "production", "os", map[string]interface{}{
+			"family":  "RedHat",
+			"release": map[string]interface{}{"major": "7"},
+		}},
+	}
+	if !reflect.DeepEqual(facts, want) {
+		t.Errorf("NodeFactsNative() returned %+v, want %+v", facts, want)
+	}
+}
+
+func TestNodeFactsEscapesCertname(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes/",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.URL.EscapedPath(); got != "/pdb/query/v4/nodes/node%201/facts" {
+				t.Errorf("NodeFacts() requested path %s", got)
+			}
+			fmt.Fprint(w, `[{"certname": "node 1", "environment": "production", "name": "kernel", "value": "Linux"}]`)
+		})
+
+	facts, err := client.NodeFacts("node 1")
+	if err != nil {
+		t.Errorf("NodeFacts() returned error: %v", err)
+	}
+	if len(facts) != 1 || facts[0].CertName != "node 1" {
+		t.Errorf("NodeFacts() returned %+v", facts)
+	}
+}
+
+func TestDiffNodeFacts(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes/node1/facts",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[
+				{"certname": "node1", "environment": "production", "name": "os", "value": {"family": "RedHat", "release": {"major": "7", "minor": "6"}}},
+				{"certname": "node1", "environment": "production", "name": "kernel", "value": "Linux"},
+				{"certname": "node1", "environment": "production", "name": "datacenter", "value": "ams1"}
+			]`)
+		})
+	mux.HandleFunc("/pdb/query/v4/nodes/node2/facts",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[
+				{"certname": "node2", "environment": "production", "name": "kernel", "value": "Linux"},
+				{"certname": "node2", "environment": "production", "name": "os", "value": {"release": {"minor": "5", "major": "7"}, "family": "RedHat"}}
+			]`)
+		})
+
+	diff, err := client.DiffNodeFacts("node1", "node2")
+	if err != nil {
+		t.Errorf("DiffNodeFacts() returned error: %v", err)
+	}
+	if len(diff.OnlyA) != 1 || diff.OnlyA[0].Name != "datacenter" {
+		t.Errorf("DiffNodeFacts() returned only on node1 %+v, want datacenter", diff.OnlyA)
+	}
+	if len(diff.OnlyB) != 0 {
+		t.Errorf("DiffNodeFacts() returned only on node2 %+v, want none", diff.OnlyB)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].Name != "os" {
+		t.Fatalf("DiffNodeFacts() returned changed %+v, want os", diff.Changed)
+	}
+	if got := diff.Changed[0].B.Path("release.minor").Data(); got != "5" {
+		t.Errorf("DiffNodeFacts() returned node2 os.release.minor %v, want 5", got)
+	}
+}
+
+func TestFactsChangedSince(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/factsets",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `[">","producer_timestamp","2019-02-19T13:00:00Z"]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("FactsChangedSince() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{
+				"certname": "node1",
+				"environment": "production",
+				"timestamp": "2019-02-19T13:27:21.312Z",
+				"producer_timestamp": "2019-02-19T13:27:20.980Z",
+				"hash": "abc123",
+				"producer": "master1",
+				"facts": {
+					"href": "/pdb/query/v4/factsets/node1/facts",
+					"data": [{"name": "osfamily", "value": "RedHat"}]
+				}
+			}]`)
+		})
+
+	since := time.Date(2019, 2, 19, 14, 0, 0, 0, time.FixedZone("CET", 3600))
+	factsets, err := client.FactsChangedSince(since)
+	if err != nil {
+		t.Errorf("FactsChangedSince() returned error: %v", err)
+	}
+	want := []FactsetJSON{{
+		Certname:          "node1",
+		Environment:       "production",
+		Timestamp:         "2019-02-19T13:27:21.312Z",
+		ProducerTimestamp: "2019-02-19T13:27:20.980Z",
+		Hash:              "abc123",
+		Producer:          "master1",
+		Facts: FactsetData{
+			Href: "/pdb/query/v4/factsets/node1/facts",
+			Data: []FactsetFactJSON{{Name: "osfamily", Value: "RedHat"}},
+		},
+	}}
+	if !reflect.DeepEqual(factsets, want) {
+		t.Errorf("FactsChangedSince() returned %+v, want %+v", factsets, want)
+	}
+}
+
+func TestMetricResourcesPerNode(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/metrics/mbean/com.puppetlabs.puppetdb.query.population:type=default,name=avg-resources-per-node",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{"Value" : 309.130}`)
+		})
+
+	value, err := client.MetricResourcesPerNode()
+	if err != nil {
+		t.Errorf("Nodes() returned error: %v", err)
+	}
+
+	want := 309.130
+	if want != value {
+		t.Errorf("Nodes() returned %f, want %f",
+			value, want)
+	}
+}
+
+func TestMetricVersionDetectionFailureCached(t *testing.T) {
+	setup()
+	defer teardown()
+
+	versionRequests := 0
+	mux.HandleFunc("/pdb/meta/v1/version",
+		func(w http.ResponseWriter, r *http.Request) {
+			versionRequests++
+			http.NotFound(w, r)
+		})
+	mux.HandleFunc("/pdb/query/v4/metrics/mbean/com.puppetlabs.puppetdb.query.population:type=default,name=num-nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{"Value" : 42}`)
+		})
+
+	for i := 0; i < 3; i++ {
+		value, err := client.MetricNumNodes()
+		if err != nil || value != 42 {
+			t.Errorf("MetricNumNodes() returned %f, %v, want 42, nil", value, err)
+		}
+	}
+	if versionRequests != 1 {
+		t.Errorf("Metric() detected the version %d times, want 1", versionRequests)
+	}
+}
+
+func TestMetricV5UsesMbeanPath(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/meta/v1/version",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{ "version" : "5.2.19" }`)
+		})
+	mux.HandleFunc("/pdb/query/v4/metrics/mbean/com.puppetlabs.puppetdb.query.population:type=default,name=num-nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{"Value" : 42}`)
+		})
+
+	value, err := client.MetricNumNodes()
+	if err != nil {
+		t.Errorf("MetricNumNodes() returned error: %v", err)
+	}
+	if value != 42 {
+		t.Errorf("MetricNumNodes() returned %f, want %f", value, 42.0)
+	}
+	if client.version != 5 {
+		t.Errorf("DetectVersion() cached %d, want %d", client.version, 5)
+	}
+}
+
+func TestMetricDisabled(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/metrics/mbean/com.puppetlabs.puppetdb.query.population:type=default,name=num-nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			http.NotFound(w, r)
+		})
+
+	_, err := client.MetricNumNodes()
+	if err != ErrMetricsDisabled {
+		t.Errorf("MetricNumNodes() returned error %v, want %v", err, ErrMetricsDisabled)
+	}
+}
+
+func TestMetricV7UsesV2Path(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/meta/v1/version",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{ "version" : "7.9.1" }`)
+		})
+	mux.HandleFunc("/metrics/v2/read/puppetlabs.puppetdb.population:name=num-nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{"request": {"type": "read"}, "value": {"Value": 42}, "status": 200}`)
+		})
+
+	value, err := client.MetricNumNodes()
+	if err != nil {
+		t.Errorf("MetricNumNodes() returned error: %v", err)
+	}
+	if value != 42 {
+		t.Errorf("MetricNumNodes() returned %f, want %f", value, 42.0)
+	}
+	if client.version != 7 {
+		t.Errorf("DetectVersion() cached %d, want %d", client.version, 7)
+	}
+}
+
+func TestMetricFloat(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/metrics/v2/read/puppetlabs.puppetdb.storage:name=gc-time",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{"request": {"type": "read"}, "value": {"Value": 12.75}, "status": 200}`)
+		})
+	mux.HandleFunc("/metrics/v2/read/puppetlabs.puppetdb.population:name=num-nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{"request": {"type": "read"}, "value": {"Value": 1342}, "status": 200}`)
+		})
+
+	value, err := client.MetricFloat("puppetlabs.puppetdb.storage:name=gc-time")
+	if err != nil {
+		t.Errorf("MetricFloat() returned error: %v", err)
+	}
+	if value != 12.75 {
+		t.Errorf("MetricFloat() returned %f, want %f", value, 12.75)
+	}
+	count, err := client.MetricInt("puppetlabs.puppetdb.population:name=num-nodes")
+	if err != nil {
+		t.Errorf("MetricInt() returned error: %v", err)
+	}
+	if count != 1342 {
+		t.Errorf("MetricInt() returned %d, want %d", count, 1342)
+	}
+}
+
+func TestMetricFloatMissingMbean(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/metrics/v2/read/puppetlabs.puppetdb.population:name=no-such-metric",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{"request": {"type": "read"}, "error_type": "javax.management.InstanceNotFoundException", "error": "javax.management.InstanceNotFoundException : puppetlabs.puppetdb.population:name=no-such-metric", "status": 404}`)
+		})
+
+	_, err := client.MetricFloat("puppetlabs.puppetdb.population:name=no-such-metric")
+	if err == nil {
+		t.Fatalf("MetricFloat() returned no error for a missing mbean")
+	}
+	if !strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "InstanceNotFoundException") {
+		t.Errorf("MetricFloat() returned error %q, want the jolokia status and error", err)
+	}
+	if _, err := client.MetricInt("puppetlabs.puppetdb.population:name=no-such-metric"); err == nil {
+		t.Errorf("MetricInt() returned no error for a missing mbean")
+	}
+}
+
+func TestPuppetdbVersion(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/version",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{ "version" : "2.2.0" },`)
+		})
+
+	facts, err := client.PuppetdbVersion()
+	if err != nil {
+		t.Errorf("PuppetdbVersion() returned error: %v", err)
+	}
+	want := Version{"2.2.0"}
+	if !reflect.DeepEqual(facts, want) {
+		t.Errorf("PuppetdbVersion() returned %+v, want %+v",
+			facts, want)
+	}
+}
+
+func TestVersionSemVer(t *testing.T) {
+	tests := []struct {
+		version             string
+		major, minor, patch int
+		atLeast69           bool
+	}{
+		{"6.21.0", 6, 21, 0, true},
+		{"6.8.3-SNAPSHOT", 6, 8, 3, false},
+	}
+	for _, tt := range tests {
+		v := Version{tt.version}
+		major, minor, patch, err := v.SemVer()
+		if err != nil {
+			t.Errorf("SemVer(%s) returned error: %v", tt.version, err)
+		}
+		if major != tt.major || minor != tt.minor || patch != tt.patch {
+			t.Errorf("SemVer(%s) returned %d.%d.%d, want %d.%d.%d",
+				tt.version, major, minor, patch, tt.major, tt.minor, tt.patch)
+		}
+		if v.AtLeast(6, 9) != tt.atLeast69 {
+			t.Errorf("AtLeast(6, 9) for %s returned %v, want %v", tt.version, !tt.atLeast69, tt.atLeast69)
+		}
+	}
+
+	if _, _, _, err := (Version{"unknown"}).SemVer(); err == nil {
+		t.Errorf("SemVer(unknown) returned no error")
+	}
+}
+
+func TestNodeReports(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{
+				"catalog_uuid": "3c77fcff-7e95-4129-a28f-af86566db52f",
+				"receive_time": "2019-02-19T13:27:21.312Z",
+				"producer": "puppet",
+				"hash": "ceb97994c50a968859dd44b90e14bb08e54e53ea",
+				"transaction_uuid": "005d2b4a-5a89-4096-9f81-ecc65f1e9082",
+				"puppet_version": "5.5.1",
+				"noop": false,
+				"corrective_change": null,
+				"logs": {
+					"data": [
+						{
+							"file": null,
+							"line": null,
+							"tags": [
+								"notice"
+							],
+							"time": "2019-02-19T14:27:21.032+01:00",
+							"level": "notice",
+							"source": "Puppet",
+							"message": "Applied catalog in 6.69 seconds"
+						}
+					],
+					"href": "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/logs"
+				},
+				"report_format": 9,
+				"start_time": "2019-02-19T13:27:04.740Z",
+				"producer_timestamp": "2019-02-19T13:27:21.282Z",
+				"cached_catalog_status": "not_used",
+				"end_time": "2019-02-19T13:27:20.973Z",
+				"resource_events": {
+					"data": null,
+					"href": "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/events"
+				},
+				"status": "unchanged",
+				"configuration_version": "1550582831",
+				"environment": "development",
+				"code_id": null,
+				"noop_pending": false,
+				"certname": "node",
+				"metrics": {
+					"data": [
+						{
+							"name": "changed",
+							"value": 0,
+							"category": "resources"
+						}
+					],
+					"href": "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/metrics"
+				},
+				"job_id": null
+			}]`)
+		})
+
+	facts, err := client.Reports("", nil)
+	if err != nil {
+		t.Errorf("NodesReports() returned error: %v", err)
+	}
+	want := []ReportJSON{ReportJSON{
+		CatalogUUID:          "3c77fcff-7e95-4129-a28f-af86566db52f",
+		ReceiveTime:          "2019-02-19T13:27:21.312Z",
+		Producer:             "puppet",
+		Hash:                 "ceb97994c50a968859dd44b90e14bb08e54e53ea",
+		TransactionUUID:      "005d2b4a-5a89-4096-9f81-ecc65f1e9082",
+		PuppetVersion:        "5.5.1",
+		Noop:                 false,
+		CorrectiveChange:     nil,
+		ReportFormat:         9,
+		StartTime:            "2019-02-19T13:27:04.740Z",
+		ProducerTimestamp:    "2019-02-19T13:27:21.282Z",
+		CachedCatalogStatus:  "not_used",
+		EndTime:              "2019-02-19T13:27:20.973Z",
+		Status:               "unchanged",
+		ConfigurationVersion: "1550582831",
+		Environment:          "development",
+		CodeID:               "",
+		NoopPending:          false,
+		CertName:             "node",
+		ResourceEvents: PuppetReportResource{
+			Href: "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/events",
+		},
+		Logs: PuppetReportLog{
+			Data: []PuppetReportMetricsLogEntry{
+				PuppetReportMetricsLogEntry{
+					NewValue: "",
+					Property: "",
+					File:     "",
+					Line:     "",
+					Tags: []string{
+						"notice",
+					},
+					Time:    "2019-02-19T14:27:21.032+01:00",
+					Level:   "notice",
+					Source:  "Puppet",
+					Message: "Applied catalog in 6.69 seconds",
+				},
+			},
+			Href: "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/logs",
+		},
+		Metrics: PuppetReportMetrics{
+			Href: "/pdb/query/v4/reports/ceb97994c50a968859dd44b90e14bb08e54e53ea/metrics",
+			Data: []PuppetReportMetricsDataEntry{
+				PuppetReportMetricsDataEntry{
+					Name:     "changed",
+					Value:    0,
+					Category: "resources",
+				},
+			},
+		},
+	}}
+	if !reflect.DeepEqual(facts, want) {
+		t.Errorf("NodeReports() returned %+v, want %+v",
+			facts, want)
+	}
+}
+
+func TestChangedReports(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			switch r.URL.Query().Get("query") {
+			case `["and",["or",["=","status","changed"],["=","noop_pending",true]],["=","certname","node"]]`:
+				fmt.Fprint(w, `[
+					{"certname": "node", "hash": "enforced", "status": "changed", "noop": false, "noop_pending": false},
+					{"certname": "node", "hash": "simulated", "status": "unchanged", "noop": true, "noop_pending": true}
+				]`)
+			case `["and",["=","status","changed"],["=","noop",false],["=","certname","node"]]`:
+				fmt.Fprint(w, `[{"certname": "node", "hash": "enforced", "status": "changed", "noop": false, "noop_pending": false}]`)
+			default:
+				t.Errorf("ChangedReports() sent query %s", r.URL.Query().Get("query"))
+				fmt.Fprint(w, `[]`)
+			}
+		})
+
+	tests := []struct {
+		includeNoop bool
+		want        []string
+	}{
+		{true, []string{"enforced", "simulated"}},
+		{false, []string{"enforced"}},
+	}
+	for _, tt := range tests {
+		reports, err := client.ChangedReports(tt.includeNoop, `["=","certname","node"]`)
+		if err != nil {
+			t.Errorf("ChangedReports() returned error: %v", err)
+		}
+		got := []string{}
+		for _, report := range reports {
+			got = append(got, report.Hash)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ChangedReports(%v) returned %v, want %v", tt.includeNoop, got, tt.want)
+		}
+		if tt.includeNoop && len(reports) == 2 && !reports[1].NoopPending {
+			t.Errorf("ChangedReports(true) returned %+v, want the simulated report noop pending", reports[1])
+		}
+	}
+}
+
+func TestDiffReports(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/events",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			switch r.URL.Query().Get("query") {
+			case `["=","report","aaa"]`:
+				fmt.Fprint(w, `[
+					{"resource_type": "File", "resource_title": "/etc/motd", "property": "content", "status": "success", "new_value": "v1"},
+					{"resource_type": "Service", "resource_title": "ntpd", "property": "ensure", "status": "success", "new_value": "running"},
+					{"resource_type": "User", "resource_title": "deploy", "property": "groups", "status": "success", "old_value": ["wheel"], "new_value": ["wheel", "docker"]}
+				]`)
+			case `["=","report","bbb"]`:
+				fmt.Fprint(w, `[
+					{"resource_type": "File", "resource_title": "/etc/motd", "property": "content", "status": "success", "new_value": "v2"},
+					{"resource_type": "User", "resource_title": "deploy", "property": "groups", "status": "success", "old_value": ["wheel"], "new_value": ["wheel", "docker"]},
+					{"resource_type": "Package", "resource_title": "ntp", "property": "ensure", "status": "success", "new_value": "present"}
+				]`)
+			default:
+				t.Errorf("Unexpected events query %s", r.URL.Query().Get("query"))
+			}
+		})
+
+	diff, err := client.DiffReports("aaa", "bbb")
+	if err != nil {
+		t.Errorf("DiffReports() returned error: %v", err)
+	}
+	want := ReportDiff{
+		Added:   []EventJSON{{ResourceType: "Package", ResourceTitle: "ntp", Property: "ensure", Status: "success", NewValue: "present"}},
+		Removed: []EventJSON{{ResourceType: "Service", ResourceTitle: "ntpd", Property: "ensure", Status: "success", NewValue: "running"}},
+		Changed: []EventChange{{
+			Old: EventJSON{ResourceType: "File", ResourceTitle: "/etc/motd", Property: "content", Status: "success", NewValue: "v1"},
+			New: EventJSON{ResourceType: "File", ResourceTitle: "/etc/motd", Property: "content", Status: "success", NewValue: "v2"},
+		}},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("DiffReports() returned %+v, want %+v", diff, want)
+	}
+}
+
+func TestDiffEventsMultipleProperties(t *testing.T) {
+	events := []EventJSON{
+		{ResourceType: "File", ResourceTitle: "/etc/motd", Property: "content", Status: "success", NewValue: "{md5}abc"},
+		{ResourceType: "File", ResourceTitle: "/etc/motd", Property: "mode", Status: "success", OldValue: "0600", NewValue: "0644"},
+	}
+	if diff := diffEvents(events, events); !reflect.DeepEqual(diff, ReportDiff{}) {
+		t.Errorf("diffEvents() of a report with itself returned %+v, want no differences", diff)
+	}
+
+	changed := []EventJSON{events[0],
+		{ResourceType: "File", ResourceTitle: "/etc/motd", Property: "mode", Status: "success", OldValue: "0600", NewValue: "0640"}}
+	want := ReportDiff{Changed: []EventChange{{events[1], changed[1]}}}
+	if diff := diffEvents(events, changed); !reflect.DeepEqual(diff, want) {
+		t.Errorf("diffEvents() returned %+v, want %+v", diff, want)
+	}
+}
+
+func TestEventsStructuredValues(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/events",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{"certname": "node1", "resource_type": "User", "resource_title": "deploy",
+				"property": "groups", "old_value": ["wheel"], "new_value": {"groups": ["wheel", "docker"], "managed": true},
+				"containment_path": ["Stage[main]", "Users", "User[deploy]"]}]`)
+		})
+
+	events, err := client.Events("", nil)
+	if err != nil {
+		t.Fatalf("Events() returned error: %v", err)
+	}
+	want := EventJSON{
+		CertName:        "node1",
+		ResourceType:    "User",
+		ResourceTitle:   "deploy",
+		Property:        "groups",
+		OldValue:        []interface{}{"wheel"},
+		NewValue:        map[string]interface{}{"groups": []interface{}{"wheel", "docker"}, "managed": true},
+		ContainmentPath: []string{"Stage[main]", "Users", "User[deploy]"},
+	}
+	if len(events) != 1 || !reflect.DeepEqual(events[0], want) {
+		t.Errorf("Events() returned %+v, want %+v", events, want)
+	}
+}
+
+func TestCatalogResources(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/catalogs/node123/resources",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[
+				{"certname": "node123", "type": "Class", "title": "Ntp", "tags": ["class", "ntp"], "parameters": {}},
+				{"certname": "node123", "type": "Service", "title": "ntpd", "tags": ["ntp"], "line": 12, "parameters": {"ensure": "running"}}
+			]`)
+		})
+
+	resources, err := client.CatalogResources("node123")
+	if err != nil {
+		t.Errorf("CatalogResources() returned error: %v", err)
+	}
+	want := []Resource{
+		{Certname: "node123", Type: "Class", Title: "Ntp", Tags: []string{"class", "ntp"}, Paramaters: map[string]interface{}{}},
+		{Certname: "node123", Type: "Service", Title: "ntpd", Tags: []string{"ntp"}, Line: 12,
+			Paramaters: map[string]interface{}{"ensure": "running"}},
+	}
+	if !reflect.DeepEqual(resources, want) {
+		t.Errorf("CatalogResources() returned %+v, want %+v", resources, want)
+	}
+}
+
+func TestCatalogEdges(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/catalogs/node123/edges",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{
+				"certname": "node123",
+				"relationship": "contains",
+				"source_type": "Class",
+				"source_title": "Ntp",
+				"target_type": "Service",
+				"target_title": "ntpd"
+			}]`)
+		})
+
+	edges, err := client.CatalogEdges("node123")
+	if err != nil {
+		t.Errorf("CatalogEdges() returned error: %v", err)
+	}
+	want := []EdgeJSON{{"node123", "contains", "Class", "Ntp", "Service", "ntpd"}}
+	if !reflect.DeepEqual(edges, want) {
+		t.Errorf("CatalogEdges() returned %+v, want %+v", edges, want)
+	}
+}
+
+func TestCatalogSummary(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/catalogs/node123",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `{
+				"certname": "node123",
+				"environment": "production",
+				"resources": {
+					"href": "/pdb/query/v4/catalogs/node123/resources",
+					"data": [
+						{"type": "Class", "title": "Main", "tags": ["class"], "exported": false, "parameters": {}},
+						{"type": "Class", "title": "Ntp", "tags": ["class", "ntp"], "exported": false, "parameters": {}},
+						{"type": "Service", "title": "ntpd", "tags": ["ntp"], "exported": false, "parameters": {"ensure": "running"}}
+					]
+				}
+			}`)
+		})
+
+	summary, err := client.CatalogSummary("node123")
+	if err != nil {
+		t.Errorf("CatalogSummary() returned error: %v", err)
+	}
+	want := CatalogSummaryJSON{ResourceCount: 3, Classes: []string{"Main", "Ntp"}}
+	if !reflect.DeepEqual(summary, want) {
+		t.Errorf("CatalogSummary() returned %+v, want %+v", summary, want)
+	}
+}
+
+func TestReportsAll(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.URL.Query().Get("limit"); got != "2" {
+				t.Errorf("ReportsAll() sent limit %s, want 2", got)
+			}
+			switch r.URL.Query().Get("offset") {
+			case "0":
+				fmt.Fprint(w, `[{"hash": "a"}, {"hash": "b"}]`)
+			case "2":
+				fmt.Fprint(w, `[{"hash": "c"}]`)
+			default:
+				t.Errorf("ReportsAll() sent unexpected offset %s", r.URL.Query().Get("offset"))
+			}
+		})
+
+	reports, err := client.ReportsAll("", 2)
+	if err != nil {
+		t.Errorf("ReportsAll() returned error: %v", err)
+	}
+	want := []ReportJSON{{Hash: "a"}, {Hash: "b"}, {Hash: "c"}}
+	if !reflect.DeepEqual(reports, want) {
+		t.Errorf("ReportsAll() returned %+v, want %+v", reports, want)
+	}
+}
+
+func TestReportFull(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{"certname": "node", "hash": "ceb979", "status": "changed"}]`)
+		})
+	mux.HandleFunc("/pdb/query/v4/reports/ceb979/logs",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{"level": "notice", "source": "Puppet", "message": "Applied catalog in 6.69 seconds"}]`)
+		})
+	mux.HandleFunc("/pdb/query/v4/reports/ceb979/metrics",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{"name": "changed", "value": 1, "category": "resources"}]`)
+		})
+	mux.HandleFunc("/pdb/query/v4/reports/ceb979/events",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{"certname": "node", "resource_type": "File", "resource_title": "/etc/motd", "status": "success"}]`)
+		})
+
+	report, err := client.ReportFull("ceb979")
+	if err != nil {
+		t.Errorf("ReportFull() returned error: %v", err)
+	}
+	want := ReportFull{
+		Report:  ReportJSON{CertName: "node", Hash: "ceb979", Status: "changed"},
+		Logs:    []PuppetReportMetricsLogEntry{{Level: "notice", Source: "Puppet", Message: "Applied catalog in 6.69 seconds"}},
+		Metrics: []PuppetReportMetricsDataEntry{{Name: "changed", Value: 1, Category: "resources"}},
+		Events:  []EventJSON{{CertName: "node", ResourceType: "File", ResourceTitle: "/etc/motd", Status: "success"}},
+	}
+	want.Report.ResourceEvents.Data = want.Events
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("ReportFull() returned %+v, want %+v", report, want)
+	}
+}
+
+func TestReportFullWithoutEvents(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[{"hash": "ceb979", "resource_events": {"href": "/pdb/query/v4/reports/ceb979/events", "data": null}}]`)
+		})
+	mux.HandleFunc("/pdb/query/v4/reports/ceb979/logs",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[]`)
+		})
+	mux.HandleFunc("/pdb/query/v4/reports/ceb979/metrics",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[]`)
+		})
+	mux.HandleFunc("/pdb/query/v4/reports/ceb979/events",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[]`)
+		})
+
+	reports, err := client.ReportByHash("ceb979")
+	if err != nil {
+		t.Errorf("ReportByHash() returned error: %v", err)
+	}
+	if reports[0].ResourceEvents.Data != nil {
+		t.Errorf("ReportByHash() returned loaded events %+v, want nil", reports[0].ResourceEvents.Data)
+	}
+
+	report, err := client.ReportFull("ceb979")
+	if err != nil {
+		t.Errorf("ReportFull() returned error: %v", err)
+	}
+	if report.Events == nil || len(report.Events) != 0 {
+		t.Errorf("ReportFull() returned events %#v, want an empty slice", report.Events)
+	}
+	if report.Report.ResourceEvents.Data == nil || len(report.Report.ResourceEvents.Data) != 0 {
+		t.Errorf("ReportFull() returned resource events %#v, want an empty slice", report.Report.ResourceEvents.Data)
+	}
+}
+
+func TestNodeDrift(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["and",["=","certname","node1"],["=","noop",true]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("NodeDrift() sent report query %s, want %s", got, want)
+			}
+			if got := r.URL.Query().Get("limit"); got != "1" {
+				t.Errorf("NodeDrift() sent limit %s, want 1", got)
+			}
+			fmt.Fprint(w, `[{"certname": "node1", "hash": "noophash", "noop": true}]`)
+		})
+	mux.HandleFunc("/pdb/query/v4/events",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["and",["=","report","noophash"],["=","status","noop"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("NodeDrift() sent event query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[
+				{"certname": "node1", "resource_type": "File", "resource_title": "/etc/motd", "status": "noop"},
+				{"certname": "node1", "resource_type": "Service", "resource_title": "ntpd", "status": "noop"}
+			]`)
+		})
+
+	drift, err := client.NodeDrift("node1")
+	if err != nil {
+		t.Errorf("NodeDrift() returned error: %v", err)
+	}
+	if drift != 2 {
+		t.Errorf("NodeDrift() returned %d, want %d", drift, 2)
+	}
+}
+
+func TestEventsInWindow(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/events",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["and",[">=","timestamp","2019-02-19T13:00:00Z"],["<","timestamp","2019-02-19T14:00:00Z"],["=","certname","node1"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("EventsInWindow() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"certname": "node1", "timestamp": "2019-02-19T13:27:21.312Z"}]`)
+		})
+
+	start := time.Date(2019, 2, 19, 13, 0, 0, 0, time.UTC)
+	events, err := client.EventsInWindow(start, start.Add(time.Hour), `["=","certname","node1"]`)
+	if err != nil {
+		t.Errorf("EventsInWindow() returned error: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("EventsInWindow() returned %d events, want 1", len(events))
+	}
+}
+
+func TestLatestEventsPerResource(t *testing.T) {
+	setup()
+	defer teardown()
+	now = func() time.Time { return time.Date(2019, 2, 19, 14, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	mux.HandleFunc("/pdb/query/v4/events",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := map[string]string{
+				"query":               `["=","certname","node1"]`,
+				"distinct_resources":  "true",
+				"distinct_start_time": "1970-01-01T00:00:00Z",
+				"distinct_end_time":   "2019-02-19T14:00:00Z",
+			}
+			for param, value := range want {
+				if got := r.URL.Query().Get(param); got != value {
+					t.Errorf("LatestEventsPerResource() sent %s %s, want %s", param, got, value)
+				}
+			}
+			fmt.Fprint(w, `[{"certname": "node1", "resource_type": "File", "resource_title": "/etc/motd"}]`)
+		})
+
+	events, err := client.LatestEventsPerResource(`["=","certname","node1"]`)
+	if err != nil {
+		t.Errorf("LatestEventsPerResource() returned error: %v", err)
+	}
+	if len(events) != 1 || events[0].ResourceTitle != "/etc/motd" {
+		t.Errorf("LatestEventsPerResource() returned %+v", events)
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	setup()
+	defer teardown()
+	eventsPageSize = 2
+	defer func() { eventsPageSize = 1000 }()
+
+	pages := map[string]string{
+		"0": `[{"certname": "node1", "resource_title": "a"}, {"certname": "node1", "resource_title": "b"}]`,
+		"2": `[{"certname": "node2", "resource_title": "c"}, {"certname": "node2", "resource_title": "d"}]`,
+		"4": `[{"certname": "node3", "resource_title": "e"}]`,
+	}
+	mux.HandleFunc("/pdb/query/v4/events",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.URL.Query().Get("query"); got != `["=","status","failure"]` {
+				t.Errorf("EventsStream() sent query %s", got)
+			}
+			if got := r.URL.Query().Get("limit"); got != "2" {
+				t.Errorf("EventsStream() sent limit %s, want 2", got)
+			}
+			page, ok := pages[r.URL.Query().Get("offset")]
+			if !ok {
+				t.Errorf("EventsStream() requested offset %s", r.URL.Query().Get("offset"))
+				page = `[]`
+			}
+			fmt.Fprint(w, page)
+		})
+
+	events, errs := client.EventsStream(context.Background(), `["=","status","failure"]`)
+	titles := []string{}
+	for event := range events {
+		titles = append(titles, event.ResourceTitle)
+	}
+	if err := <-errs; err != nil {
+		t.Errorf("EventsStream() returned error: %v", err)
+	}
+	want := []string{"a", "b", "c", "d", "e"}
+	if !reflect.DeepEqual(titles, want) {
+		t.Errorf("EventsStream() streamed %v, want %v", titles, want)
+	}
+}
+
+func TestCatalogByUUID(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/catalogs",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["=","catalog_uuid","7c1d2e3f-4a5b-6c7d-8e9f-0a1b2c3d4e5f"]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("CatalogByUUID() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"certname": "node1", "catalog_uuid": "7c1d2e3f-4a5b-6c7d-8e9f-0a1b2c3d4e5f", "version": "1550582841"}]`)
+		})
+
+	catalogs, err := client.CatalogByUUID("7c1d2e3f-4a5b-6c7d-8e9f-0a1b2c3d4e5f")
+	if err != nil {
+		t.Errorf("CatalogByUUID() returned error: %v", err)
+	}
+	if len(catalogs) != 1 || catalogs[0].Certname != "node1" || catalogs[0].Version != "1550582841" {
+		t.Errorf("CatalogByUUID() returned %+v", catalogs)
+	}
+}
+
+func TestReportCorrectiveChange(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		json string
+		want *bool
+	}{
+		{`{"corrective_change": true}`, &yes},
+		{`{"corrective_change": false}`, &no},
+		{`{"corrective_change": null}`, nil},
+		{`{}`, nil},
+	}
+	for _, tt := range tests {
+		report := ReportJSON{}
+		err := json.Unmarshal([]byte(tt.json), &report)
+		if err != nil {
+			t.Errorf("Unmarshal(%s) returned error: %v", tt.json, err)
+		}
+		if !reflect.DeepEqual(report.CorrectiveChange, tt.want) {
+			t.Errorf("Unmarshal(%s) returned corrective change %v, want %v", tt.json, report.CorrectiveChange, tt.want)
+		}
+	}
+}
+
+func TestReportByTransactionUUID(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["=","transaction_uuid","2b9d6b8a-3a8c-4d1b-9a4e-5c4a9a0e1f2d"]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("ReportByTransactionUUID() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"certname": "node1", "hash": "abc123", "transaction_uuid": "2b9d6b8a-3a8c-4d1b-9a4e-5c4a9a0e1f2d"}]`)
+		})
+
+	reports, err := client.ReportByTransactionUUID("2b9d6b8a-3a8c-4d1b-9a4e-5c4a9a0e1f2d")
+	if err != nil {
+		t.Errorf("ReportByTransactionUUID() returned error: %v", err)
+	}
+	want := []ReportJSON{{CertName: "node1", Hash: "abc123", TransactionUUID: "2b9d6b8a-3a8c-4d1b-9a4e-5c4a9a0e1f2d"}}
+	if !reflect.DeepEqual(reports, want) {
+		t.Errorf("ReportByTransactionUUID() returned %+v, want %+v", reports, want)
+	}
+}
+
+func TestReportSummary(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{
+				"certname": "node1",
+				"hash": "abc123",
+				"Status": "changed",
+				"end_time": "2019-02-19T13:27:21.282Z",
+				"metrics": {"data": [{"category": "changes", "name": "total", "value": 3}]}
+			}]`)
+		})
+
+	summary, err := client.ReportSummary("abc123")
+	if err != nil {
+		t.Errorf("ReportSummary() returned error: %v", err)
+	}
+	want := "node1 changed with 3 changes at 2019-02-19T13:27:21.282Z"
+	if summary != want {
+		t.Errorf("ReportSummary() returned %q, want %q", summary, want)
+	}
+}
+
+func TestReportMetricValue(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports/abc123/metrics",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[
+				{"category": "resources", "name": "total", "value": 412},
+				{"category": "time", "name": "file", "value": 1.25},
+				{"category": "time", "name": "total", "value": 17.5}
+			]`)
+		})
+
+	value, err := client.ReportMetricValue("abc123", "time", "total")
+	if err != nil {
+		t.Errorf("ReportMetricValue() returned error: %v", err)
+	}
+	if value != 17.5 {
+		t.Errorf("ReportMetricValue() returned %f, want %f", value, 17.5)
+	}
+	_, err = client.ReportMetricValue("abc123", "time", "exec")
+	if err == nil {
+		t.Errorf("ReportMetricValue() returned no error for a missing metric")
+	}
+}
+
+func TestPuppetReportMetrics(t *testing.T) {
+	metrics := PuppetReportMetrics{Data: []PuppetReportMetricsDataEntry{
+		{Name: "total", Value: 412, Category: "resources"},
+		{Name: "changed", Value: 3, Category: "resources"},
+		{Name: "total", Value: 17.5, Category: "time"},
+	}}
+
+	if value, ok := metrics.Get("time", "total"); !ok || value != 17.5 {
+		t.Errorf("Get() returned %f, %v, want %f, true", value, ok, 17.5)
+	}
+	if value, ok := metrics.Get("events", "failure"); ok || value != 0 {
+		t.Errorf("Get() returned %f, %v for an absent metric, want 0, false", value, ok)
+	}
+	want := map[string]float64{"total": 412, "changed": 3}
+	if got := metrics.Category("resources"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Category() returned %v, want %v", got, want)
+	}
+	if got := metrics.Category("changes"); len(got) != 0 {
+		t.Errorf("Category() returned %v for an absent category, want an empty map", got)
+	}
+}
+
+func TestChangedResourceCount(t *testing.T) {
+	report := ReportJSON{Metrics: PuppetReportMetrics{Data: []PuppetReportMetricsDataEntry{
+		{Name: "total", Value: 412, Category: "resources"},
+		{Name: "changed", Value: 3, Category: "resources"},
+		{Name: "changed", Value: 7, Category: "events"},
+	}}}
+	if got := report.ChangedResourceCount(); got != 3 {
+		t.Errorf("ChangedResourceCount() returned %d, want %d", got, 3)
+	}
+	if got := (ReportJSON{}).ChangedResourceCount(); got != 0 {
+		t.Errorf("ChangedResourceCount() returned %d for a report without metrics, want 0", got)
+	}
+}
+
+func TestReportDuration(t *testing.T) {
+	fast := ReportJSON{StartTime: "2019-02-19T13:27:21.000Z", EndTime: "2019-02-19T13:27:51.500Z"}
+	slow := ReportJSON{StartTime: "2019-02-19T13:27:21.000Z", EndTime: "2019-02-19T13:42:21.000Z"}
+
+	duration, err := fast.Duration()
+	if err != nil {
+		t.Errorf("Duration() returned error: %v", err)
+	}
+	if duration != 30500*time.Millisecond {
+		t.Errorf("Duration() returned %v, want %v", duration, 30500*time.Millisecond)
+	}
+	if fast.ExceededSLA(5 * time.Minute) {
+		t.Errorf("ExceededSLA() returned true for a run of %v", duration)
+	}
+	if !slow.ExceededSLA(5 * time.Minute) {
+		t.Errorf("ExceededSLA() returned false for a run of 15m")
+	}
+
+	malformed := ReportJSON{StartTime: "yesterday", EndTime: "2019-02-19T13:42:21.000Z"}
+	if _, err := malformed.Duration(); err == nil {
+		t.Errorf("Duration() returned no error for a malformed start time")
+	}
+	if malformed.ExceededSLA(time.Minute) {
+		t.Errorf("ExceededSLA() returned true for a malformed report")
+	}
+}
+
+func TestReportsSince(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `[">","receive_time","2019-02-19T13:27:21Z"]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("ReportsSince() sent query %s, want %s", got, want)
+			}
+			if got := r.URL.Query().Get("order_by"); got != `[{"field":"receive_time","order":"asc"}]` {
+				t.Errorf("ReportsSince() sent order_by %s", got)
+			}
+			fmt.Fprint(w, `[{"hash": "next", "receive_time": "2019-02-19T13:30:00.000Z"}]`)
+		})
+
+	cutoff := time.Date(2019, 2, 19, 13, 27, 21, 0, time.UTC)
+	reports, err := client.ReportsSince(cutoff, map[string]string{"order_by": `[{"field":"receive_time","order":"asc"}]`})
+	if err != nil {
+		t.Errorf("ReportsSince() returned error: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Hash != "next" {
+		t.Errorf("ReportsSince() returned %+v, want the report with hash next", reports)
+	}
+}
+
+func TestReportsForNodesWithFact(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["and",["in","certname",["extract","certname",["select_facts",["and",["=","name","osfamily"],["=","value","RedHat"]]]]],["=","status","failed"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("ReportsForNodesWithFact() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"hash": "rhel", "certname": "node1"}]`)
+		})
+
+	reports, err := client.ReportsForNodesWithFact("osfamily", "RedHat", `["=","status","failed"]`)
+	if err != nil {
+		t.Errorf("ReportsForNodesWithFact() returned error: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Hash != "rhel" {
+		t.Errorf("ReportsForNodesWithFact() returned %+v, want the report with hash rhel", reports)
+	}
+}
+
+func TestReportTimeRange(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.URL.Query().Get("limit"); got != "1" {
+				t.Errorf("ReportTimeRange() sent limit %s, want 1", got)
+			}
+			switch r.URL.Query().Get("order_by") {
+			case `[{"field":"receive_time","order":"asc"}]`:
+				fmt.Fprint(w, `[{"hash": "first", "receive_time": "2019-01-01T08:00:00.000Z"}]`)
+			case `[{"field":"receive_time","order":"desc"}]`:
+				fmt.Fprint(w, `[{"hash": "last", "receive_time": "2019-02-19T13:27:21.312Z"}]`)
+			default:
+				t.Errorf("ReportTimeRange() sent order_by %s", r.URL.Query().Get("order_by"))
+			}
+		})
+
+	oldest, newest, err := client.ReportTimeRange()
+	if err != nil {
+		t.Errorf("ReportTimeRange() returned error: %v", err)
+	}
+	wantOldest := time.Date(2019, 1, 1, 8, 0, 0, 0, time.UTC)
+	wantNewest := time.Date(2019, 2, 19, 13, 27, 21, 312000000, time.UTC)
+	if !oldest.Equal(wantOldest) || !newest.Equal(wantNewest) {
+		t.Errorf("ReportTimeRange() returned %v, %v, want %v, %v", oldest, newest, wantOldest, wantNewest)
+	}
+}
+
+func TestEventCounts(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/event-counts",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{
+				"subject" : {
+					"title" : "node123"
+				  },
+				 "subject-type" : "certname",
+				 "failures" : 0,
+				 "successes" : 1,
+				 "noops" : 0,
+				 "skips" : 0
+  			}]`)
+		})
+
+	facts, err := client.EventCounts("", "certname", nil)
+	if err != nil {
+		t.Errorf("EventCount() returned error: %v", err)
+	}
+	want := []EventCountJSON{EventCountJSON{"certname", map[string]string{"title": "node123"}, 0, 1, 0, 0}}
+	if !reflect.DeepEqual(facts, want) {
+		t.Errorf("EventCount() returned %+v, want %+v",
+			facts, want)
+	}
+}
+
+func TestSortNodesByReportTime(t *testing.T) {
+	nodes := []NodeJSON{
+		{Certname: "never-reported"},
+		{Certname: "old", ReportTimestamp: "2019-01-30T09:46:31.347Z"},
+		{Certname: "new", ReportTimestamp: "2019-02-19T13:27:21.312Z"},
+		{Certname: "also-old", ReportTimestamp: "2019-01-30T09:46:31.347Z"},
+	}
+	tests := []struct {
+		desc bool
+		want []string
+	}{
+		{false, []string{"old", "also-old", "new", "never-reported"}},
+		{true, []string{"new", "old", "also-old", "never-reported"}},
+	}
+	for _, tt := range tests {
+		sorted := append([]NodeJSON{}, nodes...)
+		SortNodesByReportTime(sorted, tt.desc)
+		got := []string{}
+		for _, n := range sorted {
+			got = append(got, n.Certname)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SortNodesByReportTime(%v) returned %v, want %v", tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestSortReportsByReceiveTime(t *testing.T) {
+	reports := []ReportJSON{
+		{Hash: "b", ReceiveTime: "2019-02-19T13:27:21.312Z"},
+		{Hash: "empty"},
+		{Hash: "a", ReceiveTime: "2019-02-18T13:27:21.312Z"},
+	}
+	SortReportsByReceiveTime(reports, true)
+	got := []string{reports[0].Hash, reports[1].Hash, reports[2].Hash}
+	want := []string{"b", "a", "empty"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SortReportsByReceiveTime() returned %v, want %v", got, want)
+	}
+}
+
+func TestMergeReports(t *testing.T) {
+	page1 := []ReportJSON{{Hash: "a"}, {Hash: "b"}, {Hash: "c"}}
+	page2 := []ReportJSON{{Hash: "c"}, {Hash: "d"}}
+	page3 := []ReportJSON{{Hash: "d"}, {Hash: "e"}}
+	reports := MergeReports(page1, page2, page3)
+	got := []string{}
+	for _, report := range reports {
+		got = append(got, report.Hash)
+	}
+	want := []string{"a", "b", "c", "d", "e"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeReports() returned %v, want %v", got, want)
+	}
+
+	nodes := MergeNodes([]NodeJSON{{Certname: "node1"}, {Certname: "node2"}}, []NodeJSON{{Certname: "node2"}, {Certname: "node3"}})
+	if len(nodes) != 3 || nodes[2].Certname != "node3" {
+		t.Errorf("MergeNodes() returned %+v, want node1, node2 and node3", nodes)
+	}
+
+	resources := MergeResources(
+		[]Resource{{Certname: "node1", Resource: "abc"}, {Certname: "node2", Resource: "abc"}},
+		[]Resource{{Certname: "node2", Resource: "abc"}, {Certname: "node2", Resource: "def"}})
+	if len(resources) != 3 {
+		t.Errorf("MergeResources() returned %+v, want 3 resources", resources)
+	}
+}
+
+func TestResourcesByTag(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/resources",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["and",["=","tag","security"],["=","type","File"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("ResourcesByTag() sent query %s, want %s", got, want)
+			}
+			all := []Resource{
+				{Certname: "node1", Type: "File", Title: "/etc/shadow", Tags: []string{"file", "security"}},
+				{Certname: "node1", Type: "File", Title: "/etc/motd", Tags: []string{"file"}},
+			}
+			tagged := []Resource{}
+			for _, resource := range all {
+				if stringInSlice("security", resource.Tags) {
+					tagged = append(tagged, resource)
+				}
+			}
+			json.NewEncoder(w).Encode(tagged)
+		})
+
+	resources, err := client.ResourcesByTag("security", `["=","type","File"]`)
+	if err != nil {
+		t.Errorf("ResourcesByTag() returned error: %v", err)
+	}
+	want := []Resource{{Certname: "node1", Type: "File", Title: "/etc/shadow", Tags: []string{"file", "security"}}}
+	if !reflect.DeepEqual(resources, want) {
+		t.Errorf("ResourcesByTag() returned %+v, want %+v", resources, want)
+	}
+}
+
+func TestExportedResources(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/resources",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			want := `["and",["=","exported",true],["=","type","Sshkey"]]`
+			if got := r.URL.Query().Get("query"); got != want {
+				t.Errorf("ExportedResources() sent query %s, want %s", got, want)
+			}
+			fmt.Fprint(w, `[{"certname": "node1", "type": "Sshkey", "title": "node1.example.com", "exported": true,
+				"parameters": {"type": "ssh-ed25519"}}]`)
+		})
+
+	resources, err := client.ExportedResources("Sshkey", "")
+	if err != nil {
+		t.Errorf("ExportedResources() returned error: %v", err)
+	}
+	want := []Resource{{Certname: "node1", Type: "Sshkey", Title: "node1.example.com", Exported: true,
+		Paramaters: map[string]interface{}{"type": "ssh-ed25519"}}}
+	if !reflect.DeepEqual(resources, want) {
+		t.Errorf("ExportedResources() returned %+v, want %+v", resources, want)
 	}
 }
 
@@ -2,31 +2,58 @@ package puppetdb
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
 type ClientMaster struct {
-	BaseURL    string
-	Cert       string
-	Key        string
-	httpClient *http.Client
-	verbose    bool
-}
+	BaseURL string
+	Cert    string
+	Key     string
+	// PrettyPrint indents the json response bodies logged in verbose mode.
+	PrettyPrint bool
+	// StatusLevel is the level of detail of the status service responses, one of critical, info or
+	// debug. The default is debug, which returns the metrics but also the largest responses.
+	StatusLevel DetailLevel
+	httpClient  *http.Client
+	verbose     bool
+	userAgent   string
+	// borrowTimeouts is the borrow timeout count seen by the previous JrubyPoolSaturated call, guarded
+	// by borrowTimeoutsMutex.
+	borrowTimeoutsMutex sync.Mutex
+	borrowTimeouts      int
+	borrowTimeoutsSeen  bool
+}
+
+// DetailLevel is the level of detail of a status service response
+type DetailLevel string
+
+const (
+	// LevelCritical returns only the state of a service
+	LevelCritical DetailLevel = "critical"
+	// LevelInfo returns the state and basic status of a service
+	LevelInfo DetailLevel = "info"
+	// LevelDebug returns the state, status and metrics of a service
+	LevelDebug DetailLevel = "debug"
+)
 
 // Profiler is a struct that holds the profiler metrics for the puppet master
 type Profiler struct {
 	Version      string          `json:"service_version"`
 	StateVersion int             `json:"service_status_version"`
-	DetailLevel  string          `json:"detail_level"`
+	DetailLevel  DetailLevel     `json:"detail_level"`
 	State        string          `json:"state"`
 	Status       *ProfilerStatus `json:"status"`
 }
@@ -95,7 +122,7 @@ type ProfilerCatalogMetric struct {
 type JrubyMetrics struct {
 	Version      string       `json:"service_version"`
 	StateVersion int          `json:"service_status_version"`
-	DetailLevel  string       `json:"detail_level"`
+	DetailLevel  DetailLevel  `json:"detail_level"`
 	State        string       `json:"state"`
 	Status       *JrubyStatus `json:"status"`
 }
@@ -161,7 +188,7 @@ type JrubyBorrowedInstanceReasonRequest struct {
 type MasterMetrics struct {
 	Version      string        `json:"service_version"`
 	StateVersion int           `json:"service_status_version"`
-	DetailLevel  string        `json:"detail_level"`
+	DetailLevel  DetailLevel   `json:"detail_level"`
 	State        string        `json:"state"`
 	Status       *MasterStatus `json:"status"`
 }
@@ -194,11 +221,19 @@ type MasterHttpClientMetric struct {
 	Aggregate  int       `json:"aggregate"`
 }
 
+// ID returns the parts of the metric id joined with dots, e.g. puppetdb.command.replace_catalog
+func (m MasterHttpClientMetric) ID() string {
+	if m.MetricId == nil {
+		return ""
+	}
+	return strings.Join(*m.MetricId, ".")
+}
+
 // ServiceMetrics holds metrics for jruby
 type ServiceMetrics struct {
 	Version      string         `json:"service_version"`
 	StateVersion int            `json:"service_status_version"`
-	DetailLevel  string         `json:"detail_level"`
+	DetailLevel  DetailLevel    `json:"detail_level"`
 	State        string         `json:"state"`
 	Status       *ServiceStatus `json:"status"`
 }
@@ -264,6 +299,16 @@ type ServiceJVMMetricFile struct {
 	Used int `json:"used"`
 }
 
+// MasterHealthSummary holds the key health numbers of the jruby and jvm metrics of the puppet master
+type MasterHealthSummary struct {
+	NumJrubies         int
+	FreeJrubies        int
+	BorrowTimeoutCount int
+	HeapUsed           int
+	HeapMax            int
+	CpuUsage           float64
+}
+
 func getURLMaster(host string, port int) string {
 	return fmt.Sprintf("https://%s:%v", host, port)
 }
@@ -271,66 +316,67 @@ func getURLMaster(host string, port int) string {
 // NewClientSSL gets a new client with ssl certs enabled
 func NewClientSSLMaster(host string, port int, key string, cert string, ca string, verbose bool) *ClientMaster {
 	flag.Parse()
-	cert2, err := tls.LoadX509KeyPair(cert, key)
-	if err != nil {
-		log.Println(err.Error())
-	}
-	// Load CA cert
-	caCert, err := ioutil.ReadFile(ca)
+	transport, err := buildTransport(TLSOptions{Key: key, Cert: cert, CA: ca})
 	if err != nil {
 		log.Println(err.Error())
 	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-
-	// Setup HTTPS client
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert2},
-		RootCAs:      caCertPool,
-	}
-	tlsConfig.BuildNameToCertificate()
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
-	client := &http.Client{Transport: transport}
-	return &ClientMaster{getURLMaster(host, port), cert, key, client, verbose}
+	client := &http.Client{Transport: transport, Timeout: DefaultTimeout}
+	return &ClientMaster{BaseURL: getURLMaster(host, port), Cert: cert, Key: key, httpClient: client, verbose: verbose}
 
 }
 
 // NewClientSSLInsecure returns a https connection for your puppetdb instance but trusts self signed certificates.
 func NewClientSSLInsecureMaster(host string, port int, verbose bool) *ClientMaster {
 	flag.Parse()
-
-	// Setup HTTPS client
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: true,
-	}
-	tlsConfig.BuildNameToCertificate()
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
-	client := &http.Client{Transport: transport}
-	return &ClientMaster{getURLMaster(host, port), "", "", client, verbose}
+	transport, _ := buildTransport(TLSOptions{Insecure: true})
+	client := &http.Client{Transport: transport, Timeout: DefaultTimeout}
+	return &ClientMaster{BaseURL: getURLMaster(host, port), httpClient: client, verbose: verbose}
 
 }
 
 func (c *ClientMaster) httpGet(endpoint string) (resp *http.Response, err error) {
+	return c.httpGetContext(context.Background(), endpoint, nil)
+}
+
+func (c *ClientMaster) httpGetContext(ctx context.Context, endpoint string, params map[string]string) (resp *http.Response, err error) {
 	metrics := []string{"jruby-metrics", "master", "puppet-profiler", "status-service"}
-	base := strings.TrimRight(c.BaseURL, "/")
+	base := normalizeBaseURL(c.BaseURL)
 	PUrl := ""
 	if stringInSlice(endpoint, metrics) {
-		PUrl = fmt.Sprintf("%s/status/v1/services/%s?level=debug", base, endpoint)
+		if params["level"] == "" {
+			level := c.StatusLevel
+			if level == "" {
+				level = LevelDebug
+			}
+			params = mergeParam("level", string(level), params)
+		}
+		PUrl = fmt.Sprintf("%s/status/v1/services/%s", base, endpoint)
 	} else {
 		PUrl = fmt.Sprintf("%s%s", base, endpoint)
 	}
 	if PUrl == "" {
 		return nil, errors.New("Endpoint does not exist")
 	}
+	PUrl = pathWithParams(PUrl, params)
 
 	if c.verbose == true {
 		log.Printf(PUrl)
 	}
-	return c.httpClient.Get(PUrl)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, PUrl, nil)
+	if err != nil {
+		log.Println(err.Error())
+		return nil, err
+	}
+	c.setHeaders(req)
+	return c.httpClient.Do(req)
 }
 
 func (c *ClientMaster) httpPut(endpoint string, values interface{}) (resp *http.Response, err error) {
-	base := strings.TrimRight(c.BaseURL, "/")
+	return c.httpPutContext(context.Background(), endpoint, values)
+}
+
+func (c *ClientMaster) httpPutContext(ctx context.Context, endpoint string, values interface{}) (resp *http.Response, err error) {
+	base := normalizeBaseURL(c.BaseURL)
 	PUrl := fmt.Sprintf("%s%s", base, endpoint)
 
 	if c.verbose == true {
@@ -338,12 +384,13 @@ func (c *ClientMaster) httpPut(endpoint string, values interface{}) (resp *http.
 	}
 	if values != nil {
 		json, err := json.Marshal(values)
-		req, err := http.NewRequest(http.MethodPut, PUrl, bytes.NewBuffer(json))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, PUrl, bytes.NewBuffer(json))
 		if err != nil {
 			log.Println(err.Error())
 			return nil, err
 		}
 		req.Header.Set("Content-Type", "application/json; charset=utf-8")
+		c.setHeaders(req)
 		return c.httpClient.Do(req)
 
 	}
@@ -353,25 +400,62 @@ func (c *ClientMaster) httpPut(endpoint string, values interface{}) (resp *http.
 }
 
 func (c *ClientMaster) httpDelete(endpoint string) (resp *http.Response, err error) {
-	base := strings.TrimRight(c.BaseURL, "/")
+	return c.httpDeleteContext(context.Background(), endpoint)
+}
+
+func (c *ClientMaster) httpDeleteContext(ctx context.Context, endpoint string) (resp *http.Response, err error) {
+	base := normalizeBaseURL(c.BaseURL)
 	PUrl := fmt.Sprintf("%s%s", base, endpoint)
 
 	if c.verbose == true {
 		log.Printf(PUrl)
 	}
 
-	req, err := http.NewRequest(http.MethodDelete, PUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, PUrl, nil)
 	if err != nil {
 		log.Println(err.Error())
 		return nil, err
 	}
+	c.setHeaders(req)
 	return c.httpClient.Do(req)
 }
 
-// Get gets the given url and retruns the result. In form of the given interface.
-func (c *ClientMaster) Get(v interface{}, path string) error {
+// setHeaders sets the headers configured on the client on the request.
+func (c *ClientMaster) setHeaders(req *http.Request) {
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+}
+
+// SetUserAgent sets the User-Agent header sent with every request. It returns the client so it can
+// be chained with the constructors.
+func (c *ClientMaster) SetUserAgent(ua string) *ClientMaster {
+	c.userAgent = ua
+	return c
+}
+
+// SetBaseURL sets the address of the puppet master, removing trailing slashes. It returns the client
+// so it can be chained with the constructors.
+func (c *ClientMaster) SetBaseURL(baseURL string) *ClientMaster {
+	c.BaseURL = normalizeBaseURL(baseURL)
+	return c
+}
+
+// SetTimeout sets the timeout of every request, a timeout of 0 disables it. It returns the client so
+// it can be chained with the constructors.
+func (c *ClientMaster) SetTimeout(timeout time.Duration) *ClientMaster {
+	c.httpClient.Timeout = timeout
+	return c
+}
+
+// Get gets the given url with the params and retruns the result. In form of the given interface.
+func (c *ClientMaster) Get(v interface{}, path string, params map[string]string) error {
+	return c.GetContext(context.Background(), v, path, params)
+}
 
-	resp, err := c.httpGet(path)
+// GetContext gets the given url like Get, cancelling the request when the context is done.
+func (c *ClientMaster) GetContext(ctx context.Context, v interface{}, path string, params map[string]string) error {
+	resp, err := c.httpGetContext(ctx, path, params)
 	if err != nil {
 		log.Print(err)
 		return err
@@ -381,14 +465,27 @@ func (c *ClientMaster) Get(v interface{}, path string) error {
 		log.Print(err)
 		return err
 	}
-	json.NewDecoder(resp.Body).Decode(&v)
-	return err
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Print(err)
+		return err
+	}
+	if c.verbose {
+		c.logBody(contents)
+	}
+	json.Unmarshal(contents, &v)
+	return nil
 }
 
 // Put request to the given url and returns the status code
 func (c *ClientMaster) Put(v interface{}, path string, values interface{}) (error, int) {
+	return c.PutContext(context.Background(), v, path, values)
+}
+
+// PutContext puts to the given url like Put, cancelling the request when the context is done.
+func (c *ClientMaster) PutContext(ctx context.Context, v interface{}, path string, values interface{}) (error, int) {
 	// https://gist.github.com/slav123/cbb3309052de5a870667
-	resp, err := c.httpPut(path, values)
+	resp, err := c.httpPutContext(ctx, path, values)
 	statusCode := -1
 	if resp != nil {
 		statusCode = resp.StatusCode
@@ -402,17 +499,22 @@ func (c *ClientMaster) Put(v interface{}, path string, values interface{}) (erro
 		log.Print(err)
 		return err, statusCode
 	}
+	contents, _ := ioutil.ReadAll(resp.Body)
 	if c.verbose {
-		contents, _ := ioutil.ReadAll(resp.Body)
-		log.Println(string(contents))
+		c.logBody(contents)
 	}
-	json.NewDecoder(resp.Body).Decode(&v)
+	json.Unmarshal(contents, &v)
 	return err, statusCode
 }
 
 // Delete request to the given url and returns the result code
 func (c *ClientMaster) Delete(path string) (error, int) {
-	resp, err := c.httpDelete(path)
+	return c.DeleteContext(context.Background(), path)
+}
+
+// DeleteContext deletes the given url like Delete, cancelling the request when the context is done.
+func (c *ClientMaster) DeleteContext(ctx context.Context, path string) (error, int) {
+	resp, err := c.httpDeleteContext(ctx, path)
 	statusCode := -1
 	if resp != nil {
 		statusCode = resp.StatusCode
@@ -428,70 +530,371 @@ func (c *ClientMaster) Delete(path string) (error, int) {
 	}
 	if c.verbose {
 		contents, _ := ioutil.ReadAll(resp.Body)
-		log.Println(string(contents))
+		c.logBody(contents)
 	}
 	return err, statusCode
 }
 
+// logBody logs a response body, indented when PrettyPrint is set and the body is json.
+func (c *ClientMaster) logBody(contents []byte) {
+	if c.PrettyPrint {
+		indented := bytes.Buffer{}
+		if json.Indent(&indented, contents, "", "  ") == nil {
+			contents = indented.Bytes()
+		}
+	}
+	log.Println(string(contents))
+}
+
 // profiler returns a profiler metrics object
 func (c *ClientMaster) Profiler() (Profiler, error) {
+	return c.ProfilerLevel(c.StatusLevel)
+}
+
+// ProfilerLevel returns a profiler metrics object with the given level of detail, e.g. LevelInfo
+// for cheap polling. The metrics are only returned with LevelDebug.
+func (c *ClientMaster) ProfilerLevel(level DetailLevel) (Profiler, error) {
 	ret := Profiler{}
-	err := c.Get(&ret, "puppet-profiler")
+	err := c.Get(&ret, "puppet-profiler", map[string]string{"level": string(level)})
 	return ret, err
 }
 
 // Jruby returns a jruby metrics object
 func (c *ClientMaster) Jruby() (JrubyMetrics, error) {
 	ret := JrubyMetrics{}
-	err := c.Get(&ret, "jruby-metrics")
+	err := c.Get(&ret, "jruby-metrics", nil)
 	return ret, err
 }
 
 // Master returns a master metrics object
 func (c *ClientMaster) Master() (MasterMetrics, error) {
 	ret := MasterMetrics{}
-	err := c.Get(&ret, "master")
+	err := c.Get(&ret, "master", nil)
 	return ret, err
 }
 
 // Master returns a master metrics object
 func (c *ClientMaster) Service() (ServiceMetrics, error) {
 	ret := ServiceMetrics{}
-	err := c.Get(&ret, "status-service")
+	err := c.Get(&ret, "status-service", nil)
 	return ret, err
 }
 
+// HealthSummary gets the jruby and service metrics of the puppet master and returns their key numbers
+func (c *ClientMaster) HealthSummary() (MasterHealthSummary, error) {
+	ret := MasterHealthSummary{}
+	jruby, err := c.Jruby()
+	if err != nil {
+		return ret, err
+	}
+	if jruby.Status == nil || jruby.Status.Experimental == nil || jruby.Status.Experimental.Metrics == nil {
+		return ret, errors.New("Jruby metrics missing, the status level must be debug")
+	}
+	service, err := c.Service()
+	if err != nil {
+		return ret, err
+	}
+	if service.Status == nil || service.Status.Experimental == nil || service.Status.Experimental.JVMMetrics == nil {
+		return ret, errors.New("JVM metrics missing, the status level must be debug")
+	}
+	metrics := jruby.Status.Experimental.Metrics
+	jvm := service.Status.Experimental.JVMMetrics
+	ret.NumJrubies = metrics.NumJrubies
+	ret.FreeJrubies = metrics.NumFreeJrubies
+	ret.BorrowTimeoutCount = metrics.BorrowTimeoutCount
+	ret.CpuUsage = jvm.CpuUsage
+	if jvm.HeapMemory != nil {
+		ret.HeapUsed = jvm.HeapMemory.Used
+		ret.HeapMax = jvm.HeapMemory.Max
+	}
+	return ret, nil
+}
+
+// JrubyPoolSaturated returns whether all jrubies are borrowed, or borrowing jrubies timed out since the
+// previous call. Requests queue for a jruby while the pool is saturated.
+func (c *ClientMaster) JrubyPoolSaturated() (bool, error) {
+	jruby, err := c.Jruby()
+	if err != nil {
+		return false, err
+	}
+	if jruby.Status == nil || jruby.Status.Experimental == nil || jruby.Status.Experimental.Metrics == nil {
+		return false, errors.New("Jruby metrics missing, the status level must be debug")
+	}
+	metrics := jruby.Status.Experimental.Metrics
+	c.borrowTimeoutsMutex.Lock()
+	defer c.borrowTimeoutsMutex.Unlock()
+	rising := c.borrowTimeoutsSeen && metrics.BorrowTimeoutCount > c.borrowTimeouts
+	c.borrowTimeouts = metrics.BorrowTimeoutCount
+	c.borrowTimeoutsSeen = true
+	return metrics.NumFreeJrubies == 0 || rising, nil
+}
+
+// GCPressure returns the time the jvm of the puppet master spent in garbage collection as a fraction
+// of its uptime, summing the PS Scavenge and PS MarkSweep collectors.
+func (c *ClientMaster) GCPressure() (float64, error) {
+	service, err := c.Service()
+	if err != nil {
+		return 0, err
+	}
+	if service.Status == nil || service.Status.Experimental == nil || service.Status.Experimental.JVMMetrics == nil {
+		return 0, errors.New("JVM metrics missing, the status level must be debug")
+	}
+	jvm := service.Status.Experimental.JVMMetrics
+	if jvm.UptimeMs <= 0 {
+		return 0, errors.New("JVM uptime missing")
+	}
+	gcTimeMs := 0
+	if jvm.GCStats != nil {
+		for _, collector := range []*ServiceJVMMetricPS{jvm.GCStats.PSScavenge, jvm.GCStats.PSSweep} {
+			if collector != nil {
+				gcTimeMs += collector.TotalTimeMs
+			}
+		}
+	}
+	return float64(gcTimeMs) / float64(jvm.UptimeMs), nil
+}
+
+// TopFunctions returns the n profiled functions with the highest aggregate time, sorted descending
+func (c *ClientMaster) TopFunctions(n int) ([]ProfilerFunctionMetric, error) {
+	ret := []ProfilerFunctionMetric{}
+	profiler, err := c.Profiler()
+	if err != nil {
+		return ret, err
+	}
+	if profiler.Status == nil || profiler.Status.Experimental == nil || profiler.Status.Experimental.FunctionMetrics == nil {
+		return ret, nil
+	}
+	ret = append(ret, *profiler.Status.Experimental.FunctionMetrics...)
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Aggregate > ret[j].Aggregate
+	})
+	if n >= 0 && n < len(ret) {
+		ret = ret[:n]
+	}
+	return ret, nil
+}
+
+// HttpMetricsByRoute returns the http metrics of the puppet master keyed on their route id
+func (c *ClientMaster) HttpMetricsByRoute() (map[string]MasterHttpMetric, error) {
+	ret := map[string]MasterHttpMetric{}
+	master, err := c.Master()
+	if err != nil {
+		return ret, err
+	}
+	if master.Status == nil || master.Status.Experimental == nil || master.Status.Experimental.HttpMetrics == nil {
+		return ret, nil
+	}
+	for _, metric := range *master.Status.Experimental.HttpMetrics {
+		ret[metric.RouteId] = metric
+	}
+	return ret, nil
+}
+
+// IsHealthy returns whether all services of the puppet master report the state running. Only the
+// critical level of detail is requested.
+func (c *ClientMaster) IsHealthy() (bool, error) {
+	services := map[string]ServiceMetrics{}
+	err := c.Get(&services, "/status/v1/services", map[string]string{"level": string(LevelCritical)})
+	if err != nil {
+		return false, err
+	}
+	if len(services) == 0 {
+		return false, errors.New("No service status returned")
+	}
+	for name, service := range services {
+		if service.State != "running" {
+			if c.verbose {
+				log.Printf("Service %s is %s", name, service.State)
+			}
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// HttpClientMetricsByID returns the http client metrics of the puppet master keyed on their joined metric id
+func (c *ClientMaster) HttpClientMetricsByID() (map[string]MasterHttpClientMetric, error) {
+	ret := map[string]MasterHttpClientMetric{}
+	master, err := c.Master()
+	if err != nil {
+		return ret, err
+	}
+	if master.Status == nil || master.Status.Experimental == nil || master.Status.Experimental.HttpClientMetrics == nil {
+		return ret, nil
+	}
+	for _, metric := range *master.Status.Experimental.HttpClientMetrics {
+		ret[metric.ID()] = metric
+	}
+	return ret, nil
+}
+
 // PuppetCertificatesreturns an array of puppet certificates
 func (c *ClientMaster) PuppetCertificates() ([]PuppetCertificate, error) {
+	return c.PuppetCertificatesContext(context.Background())
+}
+
+// PuppetCertificatesContext returns all certificates, cancelling the request when the context is done
+func (c *ClientMaster) PuppetCertificatesContext(ctx context.Context) ([]PuppetCertificate, error) {
 	ret := []PuppetCertificate{}
-	err := c.Get(&ret, "/puppet-ca/v1/certificate_statuses/any")
+	err := c.GetContext(ctx, &ret, "/puppet-ca/v1/certificate_statuses/any", nil)
 	return ret, err
 }
 
+// CertificateStatuses returns the certificates of the certnames keyed on their name, fetching all
+// certificates in a single request. Certnames without a certificate are missing from the map.
+func (c *ClientMaster) CertificateStatuses(certnames []string) (map[string]PuppetCertificate, error) {
+	ret := map[string]PuppetCertificate{}
+	certs, err := c.PuppetCertificates()
+	if err != nil {
+		return ret, err
+	}
+	for _, cert := range certs {
+		if stringInSlice(cert.Name, certnames) {
+			ret[cert.Name] = cert
+		}
+	}
+	return ret, nil
+}
+
 // PuppetCertificate returns a single entry of a puppet certificate
 func (c *ClientMaster) PuppetCertificate(certname string) (PuppetCertificate, error) {
 	ret := PuppetCertificate{}
 	// /puppet-ca/v1/certificate/
-	err := c.Get(&ret, "/puppet-ca/v1/certificate_status/"+certname)
+	err := c.Get(&ret, "/puppet-ca/v1/certificate_status/"+url.PathEscape(certname), nil)
+	return ret, err
+}
+
+// CertificatePEM returns the signed certificate of the certname in PEM format. The ca returns the
+// certificate as text, so it is returned as is instead of being decoded.
+func (c *ClientMaster) CertificatePEM(certname string) ([]byte, error) {
+	return c.getPEM("/puppet-ca/v1/certificate/", certname)
+}
+
+// CertificateRequests returns the certificates waiting to be signed
+func (c *ClientMaster) CertificateRequests() ([]PuppetCertificate, error) {
+	ret := []PuppetCertificate{}
+	certs, err := c.PuppetCertificates()
+	for _, cert := range certs {
+		if cert.State == "requested" {
+			ret = append(ret, cert)
+		}
+	}
 	return ret, err
 }
 
+// CertificateRequestPEM returns the certificate signing request of the certname in PEM format, e.g.
+// to inspect its alt names before signing it.
+func (c *ClientMaster) CertificateRequestPEM(certname string) ([]byte, error) {
+	return c.getPEM("/puppet-ca/v1/certificate_request/", certname)
+}
+
+// getPEM gets the PEM text of the certname from the ca endpoint.
+func (c *ClientMaster) getPEM(endpoint string, certname string) ([]byte, error) {
+	resp, err := c.httpGet(endpoint + url.PathEscape(certname))
+	if err != nil {
+		log.Print(err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s%s returned %s: %s", endpoint, certname, resp.Status, strings.TrimSpace(string(contents)))
+	}
+	if c.verbose {
+		log.Println(string(contents))
+	}
+	return contents, nil
+}
+
 // PuppetCertificateUpdateStatereturns a single entry of a puppet certificate
 func (c *ClientMaster) PuppetCertificateUpdateState(certname string, state string) (PuppetCertificateState, error, int) {
+	return c.PuppetCertificateUpdateStateContext(context.Background(), certname, state)
+}
+
+// PuppetCertificateUpdateStateContext updates the state of a certificate, cancelling the request when
+// the context is done
+func (c *ClientMaster) PuppetCertificateUpdateStateContext(ctx context.Context, certname string, state string) (PuppetCertificateState, error, int) {
 	ret := PuppetCertificateState{}
 	st := PuppetCertificateState{DesiredState: state}
 	// /puppet-ca/v1/certificate/
-	err, code := c.Put(&ret, "/puppet-ca/v1/certificate_status/"+certname, st)
+	err, code := c.PutContext(ctx, &ret, "/puppet-ca/v1/certificate_status/"+url.PathEscape(certname), st)
 	return ret, err, code
 }
 
 // PuppetCertificateDelete deletes a certificate entry
 func (c *ClientMaster) PuppetCertificateDelete(certname string) (error, int) {
+	return c.PuppetCertificateDeleteContext(context.Background(), certname)
+}
+
+// PuppetCertificateDeleteContext deletes a certificate entry, cancelling the request when the context
+// is done
+func (c *ClientMaster) PuppetCertificateDeleteContext(ctx context.Context, certname string) (error, int) {
 	// /puppet-ca/v1/certificate/
-	err, code := c.Delete("/puppet-ca/v1/certificate_status/" + certname)
+	err, code := c.DeleteContext(ctx, "/puppet-ca/v1/certificate_status/"+url.PathEscape(certname))
 	return err, code
 }
 
+// CleanCertificate revokes the certificate of the certname if it is signed and deletes it, returning
+// the status code of the delete. A certificate that is already revoked is only deleted, and a
+// certificate that does not exist is not an error and returns 404. Nothing is deleted when the state
+// of the certificate can not be read or revoking it fails, so a signed certificate always ends up in
+// the crl.
+func (c *ClientMaster) CleanCertificate(certname string) (int, error) {
+	cert, code, err := c.certificateStatus(certname)
+	if err != nil {
+		return code, err
+	}
+	if code == http.StatusNotFound {
+		return code, nil
+	}
+	if cert.State == "signed" {
+		_, err, code := c.PuppetCertificateUpdateState(certname, "revoked")
+		if err != nil {
+			return code, err
+		}
+		if code < 200 || code > 299 {
+			return code, fmt.Errorf("Revoking certificate %s returned status %d", certname, code)
+		}
+	}
+	err, code = c.PuppetCertificateDelete(certname)
+	if err != nil {
+		return code, err
+	}
+	if code != http.StatusNotFound && (code < 200 || code > 299) {
+		return code, fmt.Errorf("Deleting certificate %s returned status %d", certname, code)
+	}
+	return code, nil
+}
+
+// certificateStatus gets the status of the certificate of the certname together with the status
+// code of the response. A missing certificate returns 404 without an error, other failed responses
+// return an error.
+func (c *ClientMaster) certificateStatus(certname string) (PuppetCertificate, int, error) {
+	ret := PuppetCertificate{}
+	endpoint := "/puppet-ca/v1/certificate_status/"
+	resp, err := c.httpGet(endpoint + url.PathEscape(certname))
+	if err != nil {
+		log.Print(err)
+		return ret, -1, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ret, resp.StatusCode, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		contents, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return ret, resp.StatusCode, fmt.Errorf("%s%s returned %s: %s", endpoint, certname, resp.Status, strings.TrimSpace(string(contents)))
+	}
+	err = json.NewDecoder(resp.Body).Decode(&ret)
+	if err != nil {
+		return ret, resp.StatusCode, fmt.Errorf("Decoding the status of certificate %s: %v", certname, err)
+	}
+	return ret, resp.StatusCode, nil
+}
+
 // stringInSlice checks wether a string is in a slice https://stackoverflow.com/questions/15323767/does-go-have-if-x-in-construct-similar-to-python
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
@@ -0,0 +1,638 @@
+package puppetdb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	masterMux    *http.ServeMux
+	masterClient *ClientMaster
+	masterServer *httptest.Server
+)
+
+func setupMaster() {
+	masterMux = http.NewServeMux()
+	masterServer = httptest.NewTLSServer(masterMux)
+
+	serverURL, _ := url.Parse(masterServer.URL)
+	splitsy := strings.Split(serverURL.Host, ":")
+	port, _ := strconv.Atoi(splitsy[1])
+	masterClient = NewClientSSLInsecureMaster(splitsy[0], port, true)
+}
+
+func teardownMaster() {
+	masterServer.Close()
+}
+
+func TestMasterSetUserAgent(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/master",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if got := r.Header.Get("User-Agent"); got != "inventory-sync/1.0" {
+				t.Errorf("Request User-Agent = %q, want %q", got, "inventory-sync/1.0")
+			}
+			fmt.Fprint(w, `{"state": "running"}`)
+		})
+
+	masterClient.SetUserAgent("inventory-sync/1.0")
+	metrics, err := masterClient.Master()
+	if err != nil {
+		t.Errorf("Master() returned error: %v", err)
+	}
+	if metrics.State != "running" {
+		t.Errorf("Master() returned state %q, want %q", metrics.State, "running")
+	}
+}
+
+func TestMasterSetBaseURL(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/master",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			if !strings.HasPrefix(r.RequestURI, "/status/v1/services/master") {
+				t.Errorf("Master() requested %s", r.RequestURI)
+			}
+			fmt.Fprint(w, `{"state": "running"}`)
+		})
+
+	masterClient.SetBaseURL(masterServer.URL + "/")
+	if masterClient.BaseURL != masterServer.URL {
+		t.Errorf("SetBaseURL() BaseURL = %s, want %s", masterClient.BaseURL, masterServer.URL)
+	}
+	if _, err := masterClient.Master(); err != nil {
+		t.Errorf("Master() returned error: %v", err)
+	}
+}
+
+func TestMasterPrettyPrint(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/jruby-metrics",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"state":"running","status":{"experimental":{"metrics":{"num-jrubies":4}}}}`)
+		})
+
+	logged := bytes.Buffer{}
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	masterClient.PrettyPrint = true
+	metrics, err := masterClient.Jruby()
+	if err != nil {
+		t.Errorf("Jruby() returned error: %v", err)
+	}
+	if metrics.Status.Experimental.Metrics.NumJrubies != 4 {
+		t.Errorf("Jruby() returned %d jrubies, want 4", metrics.Status.Experimental.Metrics.NumJrubies)
+	}
+	want := "{\n  \"state\": \"running\",\n  \"status\": {\n    \"experimental\": {"
+	if !strings.Contains(logged.String(), want) {
+		t.Errorf("Jruby() logged %q, want indented json", logged.String())
+	}
+}
+
+func TestMasterStatusLevel(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	var gotLevel string
+	masterMux.HandleFunc("/status/v1/services/master",
+		func(w http.ResponseWriter, r *http.Request) {
+			gotLevel = r.URL.Query().Get("level")
+			fmt.Fprint(w, `{"state": "running"}`)
+		})
+
+	_, err := masterClient.Master()
+	if err != nil {
+		t.Errorf("Master() returned error: %v", err)
+	}
+	if gotLevel != "debug" {
+		t.Errorf("Master() sent level %q, want %q", gotLevel, "debug")
+	}
+
+	masterClient.StatusLevel = "info"
+	_, err = masterClient.Master()
+	if err != nil {
+		t.Errorf("Master() returned error: %v", err)
+	}
+	if gotLevel != "info" {
+		t.Errorf("Master() sent level %q, want %q", gotLevel, "info")
+	}
+}
+
+func TestMasterPuppetCertificateEscapesCertname(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/puppet-ca/v1/certificate_status/",
+		func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.EscapedPath(); got != "/puppet-ca/v1/certificate_status/node%201" {
+				t.Errorf("PuppetCertificate() requested path %s", got)
+			}
+			fmt.Fprint(w, `{"name": "node 1", "state": "signed"}`)
+		})
+
+	cert, err := masterClient.PuppetCertificate("node 1")
+	if err != nil {
+		t.Errorf("PuppetCertificate() returned error: %v", err)
+	}
+	if cert.Name != "node 1" || cert.State != "signed" {
+		t.Errorf("PuppetCertificate() returned %+v", cert)
+	}
+}
+
+func TestMasterHealthSummary(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/jruby-metrics",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"state": "running", "status": {"experimental": {"metrics": {
+				"num-jrubies": 8, "num-free-jrubies": 3, "borrow-timeout-count": 2}}}}`)
+		})
+	masterMux.HandleFunc("/status/v1/services/status-service",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"state": "running", "status": {"experimental": {"jvm-metrics": {
+				"cpu-usage": 12.5,
+				"heap-memory": {"committed": 2147483648, "init": 536870912, "max": 2147483648, "used": 1073741824}}}}}`)
+		})
+
+	summary, err := masterClient.HealthSummary()
+	if err != nil {
+		t.Errorf("HealthSummary() returned error: %v", err)
+	}
+	want := MasterHealthSummary{
+		NumJrubies:         8,
+		FreeJrubies:        3,
+		BorrowTimeoutCount: 2,
+		HeapUsed:           1073741824,
+		HeapMax:            2147483648,
+		CpuUsage:           12.5,
+	}
+	if summary != want {
+		t.Errorf("HealthSummary() returned %+v, want %+v", summary, want)
+	}
+}
+
+func TestMasterJrubyPoolSaturated(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	var free, timeouts int
+	masterMux.HandleFunc("/status/v1/services/jruby-metrics",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"state": "running", "status": {"experimental": {"metrics": {
+				"num-jrubies": 4, "num-free-jrubies": %d, "borrow-timeout-count": %d}}}}`, free, timeouts)
+		})
+
+	tests := []struct {
+		free     int
+		timeouts int
+		want     bool
+	}{
+		{2, 5, false},
+		{0, 5, true},
+		{1, 5, false},
+		{1, 7, true},
+		{3, 7, false},
+	}
+	for _, tt := range tests {
+		free, timeouts = tt.free, tt.timeouts
+		saturated, err := masterClient.JrubyPoolSaturated()
+		if err != nil {
+			t.Errorf("JrubyPoolSaturated() returned error: %v", err)
+		}
+		if saturated != tt.want {
+			t.Errorf("JrubyPoolSaturated() with %d free and %d timeouts returned %v, want %v",
+				tt.free, tt.timeouts, saturated, tt.want)
+		}
+	}
+}
+
+func TestMasterJrubyPoolSaturatedConcurrent(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/jruby-metrics",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"state": "running", "status": {"experimental": {"metrics": {
+				"num-jrubies": 4, "num-free-jrubies": 2, "borrow-timeout-count": 5}}}}`)
+		})
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			saturated, err := masterClient.JrubyPoolSaturated()
+			if err != nil || saturated {
+				t.Errorf("JrubyPoolSaturated() returned %v, %v with a constant timeout count, want false, nil", saturated, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMasterGCPressure(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/status-service",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"state": "running", "status": {"experimental": {"jvm-metrics": {
+				"up-time-ms": 1000000,
+				"gc-stats": {
+					"PS Scavenge": {"count": 300, "total-time-ms": 15000},
+					"PS MarkSweep": {"count": 4, "total-time-ms": 5000}
+				}}}}}`)
+		})
+
+	pressure, err := masterClient.GCPressure()
+	if err != nil {
+		t.Errorf("GCPressure() returned error: %v", err)
+	}
+	if pressure != 0.02 {
+		t.Errorf("GCPressure() returned %f, want %f", pressure, 0.02)
+	}
+}
+
+func TestMasterTopFunctions(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/puppet-profiler",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"state": "running", "status": {"experimental": {"function-metrics": [
+				{"function": "lookup", "count": 120, "mean": 2, "aggregate": 240},
+				{"function": "template", "count": 30, "mean": 15, "aggregate": 450},
+				{"function": "include", "count": 80, "mean": 1, "aggregate": 80}
+			]}}}`)
+		})
+
+	functions, err := masterClient.TopFunctions(2)
+	if err != nil {
+		t.Errorf("TopFunctions() returned error: %v", err)
+	}
+	want := []ProfilerFunctionMetric{
+		{Function: "template", Count: 30, Mean: 15, Aggregate: 450},
+		{Function: "lookup", Count: 120, Mean: 2, Aggregate: 240},
+	}
+	if !reflect.DeepEqual(functions, want) {
+		t.Errorf("TopFunctions() returned %+v, want %+v", functions, want)
+	}
+}
+
+func TestMasterHttpMetricsByRoute(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/master",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"state": "running", "status": {"experimental": {"http-metrics": [
+				{"route-id": "puppet-v3-catalog-/*/", "count": 1200, "mean": 850, "aggregate": 1020000},
+				{"route-id": "puppet-v3-file_metadatas-/*/", "count": 5000, "mean": 12, "aggregate": 60000}
+			]}}}`)
+		})
+
+	metrics, err := masterClient.HttpMetricsByRoute()
+	if err != nil {
+		t.Errorf("HttpMetricsByRoute() returned error: %v", err)
+	}
+	want := map[string]MasterHttpMetric{
+		"puppet-v3-catalog-/*/":        {RouteId: "puppet-v3-catalog-/*/", Count: 1200, Mean: 850, Aggregate: 1020000},
+		"puppet-v3-file_metadatas-/*/": {RouteId: "puppet-v3-file_metadatas-/*/", Count: 5000, Mean: 12, Aggregate: 60000},
+	}
+	if !reflect.DeepEqual(metrics, want) {
+		t.Errorf("HttpMetricsByRoute() returned %+v, want %+v", metrics, want)
+	}
+}
+
+func TestMasterCertificatePEM(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	pemBody := "-----BEGIN CERTIFICATE-----\nMIIBszCCAVmgAwIBAgIBATAKBggqhkjOPQQDAjAA\n-----END CERTIFICATE-----\n"
+	masterMux.HandleFunc("/puppet-ca/v1/certificate/node1",
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprint(w, pemBody)
+		})
+
+	cert, err := masterClient.CertificatePEM("node1")
+	if err != nil {
+		t.Errorf("CertificatePEM() returned error: %v", err)
+	}
+	if string(cert) != pemBody {
+		t.Errorf("CertificatePEM() returned %q, want %q", cert, pemBody)
+	}
+
+	_, err = masterClient.CertificatePEM("unknown")
+	if err == nil {
+		t.Errorf("CertificatePEM() of an unknown certname returned no error")
+	}
+}
+
+func TestMasterCertificateRequests(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/puppet-ca/v1/certificate_statuses/any",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[
+				{"name": "node1", "state": "signed"},
+				{"name": "node2", "state": "requested", "dns_alt_names": ["DNS:node2.example.com"]},
+				{"name": "node3", "state": "revoked"}
+			]`)
+		})
+	csrBody := "-----BEGIN CERTIFICATE REQUEST-----\nMIIBWzCCAQECAQAwETEPMA0GA1UEAwwGbm9kZTI=\n-----END CERTIFICATE REQUEST-----\n"
+	masterMux.HandleFunc("/puppet-ca/v1/certificate_request/node2",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, csrBody)
+		})
+
+	requests, err := masterClient.CertificateRequests()
+	if err != nil {
+		t.Errorf("CertificateRequests() returned error: %v", err)
+	}
+	want := []PuppetCertificate{{Name: "node2", State: "requested", DNSAltNames: []string{"DNS:node2.example.com"}}}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("CertificateRequests() returned %+v, want %+v", requests, want)
+	}
+
+	csr, err := masterClient.CertificateRequestPEM("node2")
+	if err != nil {
+		t.Errorf("CertificateRequestPEM() returned error: %v", err)
+	}
+	if string(csr) != csrBody {
+		t.Errorf("CertificateRequestPEM() returned %q, want %q", csr, csrBody)
+	}
+}
+
+func TestMasterCertificateStatuses(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/puppet-ca/v1/certificate_statuses/any",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[
+				{"name": "node1", "state": "signed"},
+				{"name": "node2", "state": "requested"},
+				{"name": "node3", "state": "revoked"}
+			]`)
+		})
+
+	statuses, err := masterClient.CertificateStatuses([]string{"node1", "node2", "node4"})
+	if err != nil {
+		t.Errorf("CertificateStatuses() returned error: %v", err)
+	}
+	want := map[string]PuppetCertificate{
+		"node1": {Name: "node1", State: "signed"},
+		"node2": {Name: "node2", State: "requested"},
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("CertificateStatuses() returned %+v, want %+v", statuses, want)
+	}
+}
+
+func TestMasterCleanCertificate(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	states := map[string]string{"node1": "signed", "node2": "revoked"}
+	requests := []string{}
+	masterMux.HandleFunc("/puppet-ca/v1/certificate_status/",
+		func(w http.ResponseWriter, r *http.Request) {
+			certname := strings.TrimPrefix(r.URL.Path, "/puppet-ca/v1/certificate_status/")
+			requests = append(requests, r.Method+" "+certname)
+			state, ok := states[certname]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			switch r.Method {
+			case http.MethodGet:
+				fmt.Fprintf(w, `{"name": %q, "state": %q}`, certname, state)
+			case http.MethodPut:
+				body, _ := ioutil.ReadAll(r.Body)
+				if string(body) != `{"desired_state":"revoked"}` {
+					t.Errorf("CleanCertificate() sent body %s", body)
+				}
+				states[certname] = "revoked"
+				w.WriteHeader(http.StatusNoContent)
+			case http.MethodDelete:
+				delete(states, certname)
+				w.WriteHeader(http.StatusNoContent)
+			}
+		})
+
+	tests := []struct {
+		certname string
+		want     []string
+	}{
+		{"node1", []string{"GET node1", "PUT node1", "DELETE node1"}},
+		{"node2", []string{"GET node2", "DELETE node2"}},
+		{"node1", []string{"GET node1"}},
+	}
+	for _, tt := range tests {
+		requests = []string{}
+		code, err := masterClient.CleanCertificate(tt.certname)
+		if err != nil {
+			t.Errorf("CleanCertificate(%s) returned error: %v", tt.certname, err)
+		}
+		if code != http.StatusNoContent && code != http.StatusNotFound {
+			t.Errorf("CleanCertificate(%s) returned status %d", tt.certname, code)
+		}
+		if !reflect.DeepEqual(requests, tt.want) {
+			t.Errorf("CleanCertificate(%s) sent %v, want %v", tt.certname, requests, tt.want)
+		}
+	}
+}
+
+func TestMasterCleanCertificateStatusError(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	requests := []string{}
+	masterMux.HandleFunc("/puppet-ca/v1/certificate_status/node1",
+		func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method)
+			if r.Method == http.MethodGet {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+
+	code, err := masterClient.CleanCertificate("node1")
+	if err == nil {
+		t.Errorf("CleanCertificate() returned no error for a failing status request")
+	}
+	if code != http.StatusInternalServerError {
+		t.Errorf("CleanCertificate() returned status %d, want %d", code, http.StatusInternalServerError)
+	}
+	if !reflect.DeepEqual(requests, []string{http.MethodGet}) {
+		t.Errorf("CleanCertificate() sent %v, want only the GET", requests)
+	}
+}
+
+func TestMasterPuppetCertificateUpdateStateContext(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	release := make(chan struct{})
+	defer close(release)
+	masterMux.HandleFunc("/puppet-ca/v1/certificate_status/node1",
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPut {
+				t.Errorf("Request method = %v, want %v", r.Method, http.MethodPut)
+			}
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err, _ := masterClient.PuppetCertificateUpdateStateContext(ctx, "node1", "signed")
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Errorf("PuppetCertificateUpdateStateContext() returned error %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestMasterProfilerLevel(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/puppet-profiler",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"state": "running", "detail_level": %q}`, r.URL.Query().Get("level"))
+		})
+
+	for _, level := range []DetailLevel{LevelCritical, LevelInfo, LevelDebug} {
+		profiler, err := masterClient.ProfilerLevel(level)
+		if err != nil {
+			t.Errorf("ProfilerLevel(%s) returned error: %v", level, err)
+		}
+		if profiler.DetailLevel != level {
+			t.Errorf("ProfilerLevel(%s) sent level %s", level, profiler.DetailLevel)
+		}
+	}
+}
+
+func TestMasterIsHealthy(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	caState := "running"
+	masterMux.HandleFunc("/status/v1/services",
+		func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("level"); got != "critical" {
+				t.Errorf("IsHealthy() sent level %q, want %q", got, "critical")
+			}
+			fmt.Fprintf(w, `{
+				"jruby-metrics": {"service_version": "6.2.0", "state": "running"},
+				"ca": {"service_version": "6.2.0", "state": %q},
+				"status-service": {"service_version": "1.1.0", "state": "running"}
+			}`, caState)
+		})
+
+	for _, tt := range []struct {
+		state string
+		want  bool
+	}{{"running", true}, {"error", false}} {
+		caState = tt.state
+		healthy, err := masterClient.IsHealthy()
+		if err != nil {
+			t.Errorf("IsHealthy() returned error: %v", err)
+		}
+		if healthy != tt.want {
+			t.Errorf("IsHealthy() with ca %s returned %v, want %v", tt.state, healthy, tt.want)
+		}
+	}
+}
+
+func TestMasterGetParams(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/master",
+		func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("level"); got != "info" {
+				t.Errorf("Get() sent level %q, want %q", got, "info")
+			}
+			fmt.Fprint(w, `{"state": "running"}`)
+		})
+	masterMux.HandleFunc("/puppet-ca/v1/certificate_statuses/any",
+		func(w http.ResponseWriter, r *http.Request) {
+			if got := r.URL.Query().Get("state"); got != "requested" {
+				t.Errorf("Get() sent state %q, want %q", got, "requested")
+			}
+			fmt.Fprint(w, `[{"name": "node1", "state": "requested"}]`)
+		})
+
+	metrics := MasterMetrics{}
+	err := masterClient.Get(&metrics, "master", map[string]string{"level": "info"})
+	if err != nil {
+		t.Errorf("Get() returned error: %v", err)
+	}
+	certs := []PuppetCertificate{}
+	err = masterClient.Get(&certs, "/puppet-ca/v1/certificate_statuses/any", map[string]string{"state": "requested"})
+	if err != nil {
+		t.Errorf("Get() returned error: %v", err)
+	}
+	if len(certs) != 1 || certs[0].Name != "node1" {
+		t.Errorf("Get() returned %+v", certs)
+	}
+}
+
+func TestMasterHttpClientMetricsByID(t *testing.T) {
+	setupMaster()
+	defer teardownMaster()
+
+	masterMux.HandleFunc("/status/v1/services/master",
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"state": "running", "status": {"experimental": {"http-client-metrics": [
+				{"metric-name": "puppetlabs.localhost.http-client.experimental.with-metric-id.puppetdb.command.replace_catalog.full-response",
+					"metric-id": ["puppetdb", "command", "replace_catalog"], "count": 12, "mean": 40, "aggregate": 480},
+				{"metric-name": "puppetlabs.localhost.http-client.experimental.with-metric-id.puppetdb.query.full-response",
+					"metric-id": ["puppetdb", "query"], "count": 30, "mean": 5, "aggregate": 150}
+			]}}}`)
+		})
+
+	metrics, err := masterClient.HttpClientMetricsByID()
+	if err != nil {
+		t.Errorf("HttpClientMetricsByID() returned error: %v", err)
+	}
+	if len(metrics) != 2 {
+		t.Errorf("HttpClientMetricsByID() returned %d metrics, want 2", len(metrics))
+	}
+	if metric, ok := metrics["puppetdb.command.replace_catalog"]; !ok || metric.Aggregate != 480 {
+		t.Errorf("HttpClientMetricsByID() returned %+v for puppetdb.command.replace_catalog", metric)
+	}
+	if metric, ok := metrics["puppetdb.query"]; !ok || metric.Count != 30 {
+		t.Errorf("HttpClientMetricsByID() returned %+v for puppetdb.query", metric)
+	}
+	if id := (MasterHttpClientMetric{}).ID(); id != "" {
+		t.Errorf("ID() without metric id returned %q", id)
+	}
+}
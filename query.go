@@ -0,0 +1,156 @@
+package puppetdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Query is a puppetdb query in its AST form. It can be converted to json with QueryToJSON.
+type Query []interface{}
+
+// MatchI returns a query matching the field case-insensitively against the regular expression.
+// Puppetdb has no case-insensitive regex operator, so the ~ operator is used with the (?i)
+// embedded option of the PostgreSQL regex engine. It works for any string field that supports ~,
+// e.g. certname, title, name, environment and string fact values.
+func MatchI(field string, regex string) Query {
+	return Query{"~", field, "(?i)" + regex}
+}
+
+// Op returns a query with any operator and arguments, for operators without a builder of their own,
+// e.g. Op("~>", "path", Query{"os", ".*"}).
+func Op(operator string, args ...interface{}) Query {
+	return append(Query{operator}, args...)
+}
+
+// And returns a query matching all of the queries.
+func And(queries ...interface{}) Query {
+	return append(Query{"and"}, queries...)
+}
+
+// Or returns a query matching any of the queries.
+func Or(queries ...interface{}) Query {
+	return append(Query{"or"}, queries...)
+}
+
+// Not returns a query matching what the query does not match.
+func Not(query interface{}) Query {
+	return Query{"not", query}
+}
+
+// QueryFailed returns a query for the reports and events that failed.
+func QueryFailed() Query {
+	return Query{"=", "status", "failed"}
+}
+
+// QueryLatestReport returns a query for the latest report of every node, or the events of it.
+func QueryLatestReport() Query {
+	return Query{"=", "latest_report?", true}
+}
+
+// QueryEnvironment returns a query for the entities of the environment, e.g. reports, facts and
+// resources.
+func QueryEnvironment(env string) Query {
+	return Query{"=", "environment", env}
+}
+
+// QueryCertname returns a query for the entities of the node with the certname.
+func QueryCertname(name string) Query {
+	return Query{"=", "certname", name}
+}
+
+// SelectFactContents returns a query for the entities of the nodes with a structured fact value at
+// the path matching the operator and value, e.g. SelectFactContents([]string{"processors", "count"},
+// ">", 8) for the nodes with more than 8 processors.
+func SelectFactContents(path []string, op string, value interface{}) Query {
+	factPath := Query{}
+	for _, p := range path {
+		factPath = append(factPath, p)
+	}
+	return Query{"in", "certname",
+		Query{"extract", "certname",
+			Query{"select_fact_contents",
+				And(Query{"=", "path", factPath}, Query{op, "value", value})}}}
+}
+
+// comparisonOperators are the puppetdb operators comparing a field with a value.
+var comparisonOperators = []string{"=", "~", ">", "<", ">=", "<=", "~>", "null?"}
+
+// ValidateQuery checks that all operators used in the query are known puppetdb operators. The query
+// can be a json string or any value that marshals to a json query.
+func ValidateQuery(query interface{}) error {
+	var q interface{}
+	switch v := query.(type) {
+	case string:
+		err := json.Unmarshal([]byte(v), &q)
+		if err != nil {
+			return fmt.Errorf("Query is not valid json: %v", err)
+		}
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		json.Unmarshal(b, &q)
+	}
+	return validateQuery(q)
+}
+
+func validateQuery(q interface{}) error {
+	clause, ok := q.([]interface{})
+	if !ok || len(clause) == 0 {
+		return fmt.Errorf("Query %v is not an array", q)
+	}
+	op, ok := clause[0].(string)
+	if !ok {
+		return fmt.Errorf("Query operator %v is not a string", clause[0])
+	}
+	args := clause[1:]
+	switch {
+	case stringInSlice(op, comparisonOperators):
+		if len(args) != 2 {
+			return fmt.Errorf("Operator %q takes 2 arguments, got %d", op, len(args))
+		}
+		return nil
+	case op == "and" || op == "or":
+		return validateQueries(args)
+	case op == "not":
+		if len(args) != 1 {
+			return fmt.Errorf("Operator %q takes 1 argument, got %d", op, len(args))
+		}
+		return validateQuery(args[0])
+	case op == "in":
+		if len(args) != 2 {
+			return fmt.Errorf("Operator %q takes 2 arguments, got %d", op, len(args))
+		}
+		if values, ok := args[1].([]interface{}); ok && len(values) > 0 && values[0] == "array" {
+			return nil
+		}
+		return validateQuery(args[1])
+	case op == "extract":
+		if len(args) == 0 {
+			return fmt.Errorf("Operator %q needs the fields to extract", op)
+		}
+		return validateQueries(args[1:])
+	case op == "subquery" || op == "from":
+		if len(args) == 0 {
+			return fmt.Errorf("Operator %q needs an entity", op)
+		}
+		return validateQueries(args[1:])
+	case strings.HasPrefix(op, "select_"):
+		return validateQueries(args)
+	case op == "group_by" || op == "limit" || op == "offset" || op == "order_by":
+		return nil
+	}
+	return fmt.Errorf("Unknown query operator %q", op)
+}
+
+func validateQueries(queries []interface{}) error {
+	for _, q := range queries {
+		err := validateQuery(q)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package puppetdb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestValidateQuery(t *testing.T) {
+	valid := []interface{}{
+		`["and",["=","certname","node123"],["not",["~","name","^os"]]]`,
+		[]interface{}{"in", "certname", []interface{}{"extract", "certname",
+			[]interface{}{"select_facts", []string{"=", "name", "datacenter"}}}},
+		`["extract",[["function","count"],"status"],["group_by","status"]]`,
+	}
+	for _, q := range valid {
+		if err := ValidateQuery(q); err != nil {
+			t.Errorf("ValidateQuery(%v) returned error: %v", q, err)
+		}
+	}
+
+	invalid := []interface{}{
+		`["eq","certname","node123"]`,
+		`["and",["=","certname","node123"],["eq","name","os"]]`,
+		`["=","certname"]`,
+	}
+	for _, q := range invalid {
+		if err := ValidateQuery(q); err == nil {
+			t.Errorf("ValidateQuery(%v) returned no error", q)
+		}
+	}
+}
+
+func TestValidateQueriesBeforeRequest(t *testing.T) {
+	setup()
+	defer teardown()
+	client.ValidateQueries = true
+
+	requests := 0
+	mux.HandleFunc("/pdb/query/",
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			fmt.Fprint(w, `[]`)
+		})
+
+	invalid := `["eq","certname","node123"]`
+	params := map[string]string{"query": invalid}
+	_, err := client.Events(invalid, nil)
+	if err == nil {
+		t.Errorf("Events() with an unknown operator returned no error")
+	}
+	if _, err := client.GetFacts(pathWithParams("facts", params)); err == nil {
+		t.Errorf("GetFacts() with an unknown operator returned no error")
+	}
+	if err := client.GetNDJSON(ioutil.Discard, "nodes", params); err == nil {
+		t.Errorf("GetNDJSON() with an unknown operator returned no error")
+	}
+	if err := client.From("nodes", Query{"eq", "certname", "node123"}, &[]NodeJSON{}); err == nil {
+		t.Errorf("From() with an unknown operator returned no error")
+	}
+	events, errs := client.EventsStream(context.Background(), invalid)
+	for range events {
+	}
+	if err := <-errs; err == nil {
+		t.Errorf("EventsStream() with an unknown operator returned no error")
+	}
+	if requests != 0 {
+		t.Errorf("%d queries with an unknown operator were sent", requests)
+	}
+
+	err = client.Get(&[]NodeJSON{}, "nodes", map[string]string{"query": `certname = "node123"`})
+	if err != nil {
+		t.Errorf("Get() with a PQL query returned error: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("The PQL query was sent %d times, want 1", requests)
+	}
+}
+
+func TestMatchI(t *testing.T) {
+	query, err := QueryToJSON(MatchI("certname", "^web"))
+	if err != nil {
+		t.Errorf("QueryToJSON() returned error: %v", err)
+	}
+	want := `["~","certname","(?i)^web"]`
+	if query != want {
+		t.Errorf("MatchI() returned %s, want %s", query, want)
+	}
+}
+
+func TestQueryFragments(t *testing.T) {
+	tests := []struct {
+		query Query
+		want  string
+	}{
+		{And(QueryFailed(), QueryLatestReport()),
+			`["and",["=","status","failed"],["=","latest_report?",true]]`},
+		{And(QueryEnvironment("production"), Or(QueryCertname("web1"), QueryCertname("web2"))),
+			`["and",["=","environment","production"],["or",["=","certname","web1"],["=","certname","web2"]]]`},
+		{And(QueryLatestReport(), Not(QueryFailed())),
+			`["and",["=","latest_report?",true],["not",["=","status","failed"]]]`},
+	}
+	for _, tt := range tests {
+		query, err := QueryToJSON(tt.query)
+		if err != nil {
+			t.Errorf("QueryToJSON() returned error: %v", err)
+		}
+		if query != tt.want {
+			t.Errorf("QueryToJSON() returned %s, want %s", query, tt.want)
+		}
+		if err := ValidateQuery(tt.query); err != nil {
+			t.Errorf("ValidateQuery(%s) returned error: %v", query, err)
+		}
+	}
+}
+
+func TestOp(t *testing.T) {
+	query, err := QueryToJSON(And(QueryCertname("node1"), Op("~>", "path", Query{"networking", "interfaces", "eth.*"})))
+	if err != nil {
+		t.Errorf("QueryToJSON() returned error: %v", err)
+	}
+	want := `["and",["=","certname","node1"],["~>","path",["networking","interfaces","eth.*"]]]`
+	if query != want {
+		t.Errorf("Op() returned %s, want %s", query, want)
+	}
+}
+
+func TestSelectFactContents(t *testing.T) {
+	q := SelectFactContents([]string{"processors", "count"}, ">", 8)
+	query, err := QueryToJSON(q)
+	if err != nil {
+		t.Errorf("QueryToJSON() returned error: %v", err)
+	}
+	want := `["in","certname",["extract","certname",["select_fact_contents",["and",["=","path",["processors","count"]],[">","value",8]]]]]`
+	if query != want {
+		t.Errorf("SelectFactContents() returned %s, want %s", query, want)
+	}
+	if err := ValidateQuery(q); err != nil {
+		t.Errorf("ValidateQuery(%s) returned error: %v", query, err)
+	}
+}
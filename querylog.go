@@ -0,0 +1,91 @@
+package puppetdb
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// QueryLogEntry is a query sent to puppetdb, as recorded by the query log of the client.
+type QueryLogEntry struct {
+	// Time is when the query was sent.
+	Time time.Time
+	// Path is the endpoint path of the query, e.g. /pdb/query/v4/nodes.
+	Path string
+	// Query is the query param, or the body of a posted query.
+	Query string
+	// Params are the other params of the query, e.g. limit and order_by.
+	Params map[string]string
+	// Duration is the time until puppetdb responded, without reading the result.
+	Duration time.Duration
+}
+
+// queryLog is a ring buffer holding the latest queries sent by a client.
+type queryLog struct {
+	mutex   sync.Mutex
+	entries []QueryLogEntry
+	next    int
+	full    bool
+}
+
+// SetQueryLogging records the latest size queries sent to puppetdb, which are returned by QueryLog.
+// A size of 0 disables the query log. It returns the client so it can be chained with the
+// constructors.
+func (c *Client) SetQueryLogging(size int) *Client {
+	if size <= 0 {
+		c.queryLog = nil
+		return c
+	}
+	c.queryLog = &queryLog{entries: make([]QueryLogEntry, size)}
+	return c
+}
+
+// QueryLog returns the recorded queries, oldest first. It is empty unless enabled with
+// SetQueryLogging.
+func (c *Client) QueryLog() []QueryLogEntry {
+	if c.queryLog == nil {
+		return []QueryLogEntry{}
+	}
+	return c.queryLog.list()
+}
+
+// add records a query sent to the path relative to the root of the puppetdb instance. Requests that
+// are not queries, e.g. command submissions, are ignored.
+func (l *queryLog) add(sent time.Time, path string, body []byte, duration time.Duration) {
+	if !strings.HasPrefix(path, "/pdb/query/") {
+		return
+	}
+	entry := QueryLogEntry{Time: sent, Path: path, Params: map[string]string{}, Duration: duration}
+	if u, err := url.Parse(path); err == nil {
+		entry.Path = u.Path
+		for k, v := range u.Query() {
+			if k == "query" {
+				entry.Query = v[0]
+				continue
+			}
+			entry.Params[k] = v[0]
+		}
+	}
+	if body != nil {
+		entry.Query = string(body)
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns a copy of the recorded queries, oldest first.
+func (l *queryLog) list() []QueryLogEntry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.full {
+		return append([]QueryLogEntry{}, l.entries[:l.next]...)
+	}
+	return append(append([]QueryLogEntry{}, l.entries[l.next:]...), l.entries[:l.next]...)
+}
@@ -0,0 +1,47 @@
+package puppetdb
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestQueryLog(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/pdb/query/v4/nodes",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[{"certname": "node1"}]`)
+		})
+	mux.HandleFunc("/pdb/query/v4/reports",
+		func(w http.ResponseWriter, r *http.Request) {
+			testMethod(t, r, "GET")
+			fmt.Fprint(w, `[]`)
+		})
+
+	if log := client.QueryLog(); len(log) != 0 {
+		t.Errorf("QueryLog() returned %d entries without query logging, want 0", len(log))
+	}
+
+	client.SetQueryLogging(2)
+	client.Nodes()
+	client.Reports(`["=","certname","node1"]`, map[string]string{"limit": "10"})
+	client.Reports(`["=","certname","node2"]`, nil)
+
+	log := client.QueryLog()
+	if len(log) != 2 {
+		t.Fatalf("QueryLog() returned %d entries, want 2", len(log))
+	}
+	first := log[0]
+	if first.Path != "/pdb/query/v4/reports" || first.Query != `["=","certname","node1"]` || first.Params["limit"] != "10" {
+		t.Errorf("QueryLog() returned %+v as the oldest entry, want the first reports query", first)
+	}
+	if first.Time.IsZero() || first.Duration <= 0 {
+		t.Errorf("QueryLog() returned time %v and duration %v, want both set", first.Time, first.Duration)
+	}
+	if log[1].Query != `["=","certname","node2"]` {
+		t.Errorf("QueryLog() returned %+v as the newest entry, want the second reports query", log[1])
+	}
+}
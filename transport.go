@@ -0,0 +1,53 @@
+package puppetdb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// TLSOptions configures the tls of the transport of a client.
+type TLSOptions struct {
+	// Key and Cert are the files of the client certificate, which is not sent when they are empty.
+	Key  string
+	Cert string
+	// CA is the file of the certificates trusted to sign the server certificate. The system roots are
+	// trusted when it is empty.
+	CA string
+	// Insecure trusts any server certificate, e.g. self signed certificates.
+	Insecure bool
+}
+
+// buildTransport returns the transport for the tls options. When a certificate can not be loaded the
+// error is returned together with a transport without that certificate, so the constructors can log
+// the error and still return a client as before.
+func buildTransport(opts TLSOptions) (*http.Transport, error) {
+	var errs []error
+	tlsConfig := &tls.Config{InsecureSkipVerify: opts.Insecure}
+	if opts.Key != "" || opts.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.Cert, opts.Key)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+	}
+	if opts.CA != "" {
+		caCert, err := ioutil.ReadFile(opts.CA)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) && err == nil {
+			errs = append(errs, errors.New("No certificates found in "+opts.CA))
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	transport := &http.Transport{TLSClientConfig: tlsConfig}
+	if len(errs) > 0 {
+		return transport, errs[0]
+	}
+	return transport, nil
+}
@@ -0,0 +1,54 @@
+package puppetdb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestBuildTransportInsecure(t *testing.T) {
+	transport, err := buildTransport(TLSOptions{Insecure: true})
+	if err != nil {
+		t.Errorf("buildTransport() returned error: %v", err)
+	}
+	if !transport.TLSClientConfig.InsecureSkipVerify {
+		t.Errorf("buildTransport() returned a transport verifying the server certificate")
+	}
+	if len(transport.TLSClientConfig.Certificates) != 0 || transport.TLSClientConfig.RootCAs != nil {
+		t.Errorf("buildTransport() returned a transport with certificates")
+	}
+}
+
+func TestBuildTransportCA(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `[]`)
+		}))
+	defer tlsServer.Close()
+	ca := writePEM(t, "CERTIFICATE", tlsServer.Certificate().Raw)
+	defer os.Remove(ca)
+
+	transport, err := buildTransport(TLSOptions{CA: ca})
+	if err != nil {
+		t.Errorf("buildTransport() returned error: %v", err)
+	}
+	if transport.TLSClientConfig.InsecureSkipVerify {
+		t.Errorf("buildTransport() returned a transport trusting any server certificate")
+	}
+	resp, err := (&http.Client{Transport: transport}).Get(tlsServer.URL)
+	if err != nil {
+		t.Fatalf("Get() with the pinned ca returned error: %v", err)
+	}
+	resp.Body.Close()
+
+	transport, err = buildTransport(TLSOptions{CA: ca + ".missing"})
+	if err == nil {
+		t.Errorf("buildTransport() with a missing ca returned no error")
+	}
+	_, err = (&http.Client{Transport: transport}).Get(tlsServer.URL)
+	if err == nil {
+		t.Errorf("Get() without the ca trusted the server certificate")
+	}
+}